@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os/user"
+	"strings"
+	"unicode"
 
 	"github.com/BambooEngine/bamboo-core"
 )
@@ -14,6 +16,13 @@ const (
 	configDir        = "%s/.config/ibus-%s"
 	configFile       = "%s/ibus-%s.config.json"
 	mactabFile       = "%s/ibus-%s.macro.text"
+	appMactabFile    = "%s/ibus-%s.macro.%s.text"
+	lexiconFile      = "%s/ibus-%s.lexicon.text"
+	exceptionsFile   = "%s/ibus-%s.exceptions.text"
+	emojiUsageFile   = "%s/ibus-%s.emoji.text"
+	emojiDataDir     = "%s/emoji"
+	userDictFile     = "%s/ibus-%s.dict.text"
+	dictDataDir      = "%s/dict"
 	sampleMactabFile = "data/macro.tpl.txt"
 )
 
@@ -23,9 +32,28 @@ type Config struct {
 	OutputCharset          string
 	Flags                  uint
 	IBflags                uint
-	Shortcuts              [10]uint32
+	Shortcuts              [16]uint32
 	DefaultInputMode       int
 	InputModeMapping       map[string]int
+	Profiles               map[string]Profile
+	EmojiSkinTone          int
+	// MacroCommands lists the programs the `!cmd:` macros are allowed to run
+	MacroCommands []string
+	// MacroCommandTimeout is the time in milliseconds a `!cmd:` macro may run for
+	MacroCommandTimeout int
+}
+
+// Profile overrides the global settings while an application is focused.
+// The flags in FlagsOn/IBflagsOn are turned on, the ones in FlagsOff/IBflagsOff are turned off.
+type Profile struct {
+	InputMethod   string `json:",omitempty"`
+	OutputCharset string `json:",omitempty"`
+	FlagsOn       uint   `json:",omitempty"`
+	FlagsOff      uint   `json:",omitempty"`
+	IBflagsOn     uint   `json:",omitempty"`
+	IBflagsOff    uint   `json:",omitempty"`
+	// Macros names the macro file shared by the applications of the profile
+	Macros string `json:",omitempty"`
 }
 
 func GetConfigDir(ngName string) string {
@@ -40,6 +68,47 @@ func GetMacroPath(engineName string) string {
 	return fmt.Sprintf(mactabFile, GetConfigDir(engineName), engineName)
 }
 
+// GetAppMacroPath returns the path of the macros used only in an application or a profile,
+// the characters of the scope other than letters, digits, dots, dashes and underscores are
+// replaced with underscores so that the file is always in the config directory
+func GetAppMacroPath(engineName, scope string) string {
+	var name = strings.Map(func(c rune) rune {
+		if c < 128 && (unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("._-", c)) {
+			return c
+		}
+		return '_'
+	}, scope)
+	return fmt.Sprintf(appMactabFile, GetConfigDir(engineName), engineName, name)
+}
+
+func GetLexiconPath(engineName string) string {
+	return fmt.Sprintf(lexiconFile, GetConfigDir(engineName), engineName)
+}
+
+// GetExceptionsPath returns the path of the key sequences the user has restored
+func GetExceptionsPath(engineName string) string {
+	return fmt.Sprintf(exceptionsFile, GetConfigDir(engineName), engineName)
+}
+
+func GetEmojiUsagePath(engineName string) string {
+	return fmt.Sprintf(emojiUsageFile, GetConfigDir(engineName), engineName)
+}
+
+// GetEmojiDataDir returns the directory of the emoji data files added by the user
+func GetEmojiDataDir(engineName string) string {
+	return fmt.Sprintf(emojiDataDir, GetConfigDir(engineName))
+}
+
+// GetUserDictPath returns the path of the words the user has added to the spelling dictionary
+func GetUserDictPath(engineName string) string {
+	return fmt.Sprintf(userDictFile, GetConfigDir(engineName), engineName)
+}
+
+// GetDictDataDir returns the directory of the extra spelling dictionaries added by the user
+func GetDictDataDir(engineName string) string {
+	return fmt.Sprintf(dictDataDir, GetConfigDir(engineName))
+}
+
 func GetConfigPath(engineName string) string {
 	return fmt.Sprintf(configFile, GetConfigDir(engineName), engineName)
 }
@@ -51,10 +120,72 @@ func DefaultCfg() Config {
 		InputMethodDefinitions: bamboo.GetInputMethodDefinitions(),
 		Flags:                  bamboo.EstdFlags,
 		IBflags:                IBstdFlags,
-		Shortcuts:              [10]uint32{1, 126, 0, 0, 0, 0, 0, 0, 5, 117},
+		Shortcuts:              [16]uint32{1, 126, 0, 0, 0, 0, 0, 0, 5, 117, 0, 0, 0, 0, 0, 0},
 		DefaultInputMode:       PreeditIM,
 		InputModeMapping:       map[string]int{},
+		Profiles:               map[string]Profile{},
+		MacroCommands:          []string{},
+		MacroCommandTimeout:    2000,
+	}
+}
+
+// GetProfile finds the profile of an application by its full WM_CLASS or by one of its parts
+func (c *Config) GetProfile(wmClass string) (Profile, bool) {
+	if wmClass == "" {
+		return Profile{}, false
+	}
+	if p, ok := c.Profiles[wmClass]; ok {
+		return p, true
+	}
+	for _, name := range strings.Split(wmClass, ":") {
+		if p, ok := c.Profiles[name]; ok {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// GetMacroScopes returns the names of the macro files layered over the global one in an application:
+// the one of its profile, then its full WM_CLASS and the parts of it
+func (c *Config) GetMacroScopes(wmClass string) []string {
+	if wmClass == "" {
+		return nil
+	}
+	var scopes []string
+	if p, ok := c.GetProfile(wmClass); ok && p.Macros != "" {
+		scopes = append(scopes, p.Macros)
+	}
+	for _, name := range append([]string{wmClass}, strings.Split(wmClass, ":")...) {
+		var found = false
+		for _, scope := range scopes {
+			found = found || scope == name
+		}
+		if !found && name != "" {
+			scopes = append(scopes, name)
+		}
+	}
+	return scopes
+}
+
+// ApplyProfile returns the settings used in an application, or the config itself if the
+// application has no profile
+func (c *Config) ApplyProfile(wmClass string) *Config {
+	var p, ok = c.GetProfile(wmClass)
+	if !ok {
+		return c
+	}
+	var pc = *c
+	if _, found := c.InputMethodDefinitions[p.InputMethod]; found {
+		pc.InputMethod = p.InputMethod
+	}
+	for _, cs := range bamboo.GetCharsetNames() {
+		if cs == p.OutputCharset {
+			pc.OutputCharset = p.OutputCharset
+		}
 	}
+	pc.Flags = (c.Flags | p.FlagsOn) &^ p.FlagsOff
+	pc.IBflags = (c.IBflags | p.IBflagsOn) &^ p.IBflagsOff
+	return &pc
 }
 
 func LoadConfig(engineName string) *Config {
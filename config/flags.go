@@ -50,6 +50,12 @@ const (
 	_IBmouseCapturing           //deprecated
 	IBworkaroundForFBMessenger
 	IBworkaroundForWPS
+	IBwordCompletion
+	IBtoneLessTyping
+	IBreconversion
+	IBmacroSuggestion
+	IBspellingSuggestion
+	IBenglishProtection
 	IBstdFlags = IBspellCheckEnabled | IBspellCheckWithRules | IBautoNonVnRestore | IBddFreeStyle |
 		IBautoCapitalizeMacro | IBnoUnderline | IBworkaroundForWPS
 	IBUsStdFlags = 0
@@ -0,0 +1,195 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"ibus-bamboo/config"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the spelling dictionary is reloaded by the dictionary watcher while the engines read it
+var dictionaryLock sync.RWMutex
+
+// dictionaryLoaded tells if the spelling dictionary has been read from its files
+var dictionaryLoaded bool
+
+// the extensions of the files of the dictionary directory which are word lists,
+// the .aff file of a Hunspell dictionary is read along with its .dic file
+var dictionaryFileExts = map[string]bool{".dict": true, ".dic": true, ".txt": true}
+
+// getDictionaryFiles returns the dictionary shipped with ibus-bamboo and the system
+// vi_VN Hunspell dictionary followed by the user dictionary and the word lists of the
+// dictionary directory of an engine
+func getDictionaryFiles(engineName string) []string {
+	var dataFiles = []string{DictVietnameseCm}
+	for _, dicFile := range hunspellViDicts {
+		if _, err := os.Stat(dicFile); err == nil {
+			dataFiles = append(dataFiles, dicFile)
+			break
+		}
+	}
+	if _, err := os.Stat(config.GetUserDictPath(engineName)); err == nil {
+		dataFiles = append(dataFiles, config.GetUserDictPath(engineName))
+	}
+	return append(dataFiles, getDictionaryDirFiles(config.GetDictDataDir(engineName))...)
+}
+
+// getDictionaryDirFiles returns the word lists of a dictionary directory in the order of their names,
+// the other files such as READMEs and editor backups are left out
+func getDictionaryDirFiles(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") && dictionaryFileExts[filepath.Ext(f.Name())] {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	var dataFiles []string
+	for _, name := range names {
+		dataFiles = append(dataFiles, filepath.Join(dir, name))
+	}
+	return dataFiles
+}
+
+// loadDictionaries reads the spelling dictionary of an engine again and
+// reloads it from then on whenever the user's dictionary files change
+func loadDictionaries(engineName string) {
+	var words = loadDictionaryFiles(getDictionaryFiles(engineName))
+	dictionaryLock.Lock()
+	dictionary = words
+	dictionaryLoaded = true
+	dictionaryLock.Unlock()
+	dictionaryWatcher.Add(engineName)
+}
+
+// loadDictionaryFiles merges the words of the dictionary files,
+// the files which can't be read are left out
+func loadDictionaryFiles(dataFiles []string) map[string]bool {
+	var data = map[string]bool{}
+	for _, dataFile := range dataFiles {
+		words, err := loadDictionary(dataFile)
+		if err != nil {
+			log.Println("Failed to load the dictionary:", err)
+			continue
+		}
+		for word := range words {
+			data[word] = true
+		}
+	}
+	return data
+}
+
+func isDictionaryWord(word string) bool {
+	dictionaryLock.RLock()
+	defer dictionaryLock.RUnlock()
+	return dictionary[strings.ToLower(word)]
+}
+
+func isDictionaryLoaded() bool {
+	dictionaryLock.RLock()
+	defer dictionaryLock.RUnlock()
+	return dictionaryLoaded
+}
+
+// addToUserDictionary appends a word to the user dictionary file, the word is accepted
+// by the spell checking right away if the dictionary is loaded, or once it's loaded
+func addToUserDictionary(userDictFile, word string) error {
+	word = strings.ToLower(strings.TrimSpace(word))
+	if word == "" || isDictionaryWord(word) {
+		return nil
+	}
+	dictionaryLock.Lock()
+	if dictionaryLoaded {
+		dictionary[word] = true
+	}
+	dictionaryLock.Unlock()
+	if err := os.MkdirAll(filepath.Dir(userDictFile), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(userDictFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(word + "\n")
+	return err
+}
+
+// DictionaryWatcher reloads the spelling dictionary when the user dictionary
+// or the files of the dictionary directory change
+type DictionaryWatcher struct {
+	sync.Mutex
+	engines map[string]bool
+	watcher fileWatcher
+}
+
+var dictionaryWatcher = &DictionaryWatcher{
+	engines: map[string]bool{},
+}
+
+func (w *DictionaryWatcher) Add(engineName string) {
+	w.Lock()
+	defer w.Unlock()
+	if w.engines[engineName] {
+		return
+	}
+	w.engines[engineName] = true
+	if w.watcher == nil {
+		var err error
+		if w.watcher, err = newFileWatcher(w.reload); err != nil {
+			log.Println("Watching the dictionary files by polling:", err)
+			w.watcher = newPollingWatcher(w.reload, macroPollingInterval)
+		}
+	}
+	var dirs = []string{filepath.Dir(config.GetUserDictPath(engineName)), config.GetDictDataDir(engineName)}
+	for _, dir := range dirs {
+		os.MkdirAll(dir, 0755)
+		if err := w.watcher.Watch(dir); err != nil {
+			log.Println("Failed to watch the dictionary files:", err)
+		}
+	}
+}
+
+func (w *DictionaryWatcher) reload(path string) {
+	w.Lock()
+	var engineNames []string
+	for engineName := range w.engines {
+		if path == config.GetUserDictPath(engineName) || filepath.Dir(path) == config.GetDictDataDir(engineName) {
+			engineNames = append(engineNames, engineName)
+		}
+	}
+	w.Unlock()
+	for _, engineName := range engineNames {
+		var words = loadDictionaryFiles(getDictionaryFiles(engineName))
+		dictionaryLock.Lock()
+		dictionary = words
+		dictionaryLock.Unlock()
+	}
+}
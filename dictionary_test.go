@@ -0,0 +1,64 @@
+package main
+
+import (
+	"ibus-bamboo/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserDictionary(t *testing.T) {
+	defer saveWordLists()()
+	dir, err := ioutil.TempDir("", "bamboo-dict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var userDictFile = filepath.Join(dir, "user", "dict.text")
+	var extraDict = filepath.Join(dir, "brands.dict")
+	ioutil.WriteFile(extraDict, []byte("Vinamilk\n\nViettel\n"), 0644)
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBspellCheckWithDicts | config.IBautoNonVnRestore
+	_, _, typeText := newTestEngine(&cfg)
+	if dictionary, err = loadDictionary(DictVietnameseCm, extraDict); err != nil {
+		t.Fatal(err)
+	}
+	if !isDictionaryWord("tiếng") || !isDictionaryWord("vinamilk") {
+		t.Errorf("Merging the extra dictionary, expected tiếng and vinamilk")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("Dictionaries\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "brands.dict~"), []byte("Vinamilk\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "vi_VN.dic"), []byte("1\nxoong\n"), 0644)
+	if files := getDictionaryDirFiles(dir); len(files) != 2 || files[0] != extraDict {
+		t.Errorf("Listing the dictionary directory, expected [brands.dict vi_VN.dic], got %v", files)
+	}
+	if words := loadDictionaryFiles([]string{filepath.Join(dir, "missing.dict"), extraDict}); !words["viettel"] {
+		t.Errorf("Loading a missing dictionary along with brands.dict, expected viettel, got %v", words)
+	}
+
+	dictionary, dictionaryLoaded = map[string]bool{}, false
+	if err = addToUserDictionary(filepath.Join(dir, "unloaded.dict"), "boong"); err != nil {
+		t.Fatal(err)
+	}
+	if isDictionaryWord("boong") || isDictionaryLoaded() {
+		t.Errorf("Adding boong before the dictionary is loaded, expected it in the user dictionary file only")
+	}
+	dictionary, dictionaryLoaded = map[string]bool{"tiếng": true}, true
+	if s := typeText("boong "); s != "boong " {
+		t.Errorf("Typing boong before bông is added, expected `boong `, got `%s`", s)
+	}
+	if err = addToUserDictionary(userDictFile, "Bông"); err != nil {
+		t.Fatal(err)
+	}
+	if s := typeText("boong "); s != "bông " {
+		t.Errorf("Typing boong after bông is added, expected `bông `, got `%s`", s)
+	}
+	addToUserDictionary(userDictFile, "bông")
+	if content, _ := ioutil.ReadFile(userDictFile); string(content) != "bông\n" {
+		t.Errorf("Adding bông twice, expected `bông\\n` in the user dictionary, got `%s`", content)
+	}
+	if dictionary, err = loadDictionary(DictVietnameseCm, userDictFile); err != nil || !isDictionaryWord("bông") {
+		t.Errorf("Loading the user dictionary, expected bông")
+	}
+}
@@ -20,34 +20,97 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/BambooEngine/bamboo-core"
 )
 
+const MaxRecentEmojis = 18
+
+// the Fitzpatrick modifiers of the skin tones 1 to 5
+var emojiSkinToneModifiers = []string{"1f3fb", "1f3fc", "1f3fd", "1f3fe", "1f3ff"}
+
 type EmojiOne struct {
-	Name      string
-	Shortname string
-	Keywords  []string
-	ASCII     []string
+	Name        string
+	Shortname   string
+	Keywords    []string
+	ASCII       []string
+	Diversity   string
+	Diversities []string
+	Genders     []string
+	CodePoints  struct {
+		Output string
+	} `json:"code_points"`
+}
+
+// the maps below are filled by loadEmojis
+var (
+	// emojiShortnames maps the emojis to their shortnames without colons
+	emojiShortnames = map[string]string{}
+	// emojiDiversities maps the emojis to their variants of the skin tones 1 to 5
+	emojiDiversities = map[string][]string{}
+	// emojiGenders maps the emojis to their gender variants
+	emojiGenders = map[string][]string{}
+	// emojiBases maps the skin tone and gender variants to the emojis they are derived from
+	emojiBases = map[string]string{}
+	// emojiOutputs maps the emojis without variation selectors to the sequences which are committed
+	emojiOutputs = map[string]string{}
+)
+
+func parseCodePoints(hexSeq string) string {
+	var codePointStr string
+	for _, codePoint := range strings.Split(hexSeq, "-") {
+		if code, err := strconv.ParseInt(codePoint, 16, 32); err == nil {
+			codePointStr += string(rune(code))
+		}
+	}
+	return codePointStr
 }
 
 func loadEmojiOne(dataFile string) (*TrieNode, error) {
-	var trie = NewTrie()
+	return loadEmojis(dataFile)
+}
+
+// loadEmojiOneFile adds the emojis of an emojione.json file
+func loadEmojiOneFile(trie *TrieNode, dataFile string) error {
 	var c = map[string]EmojiOne{}
 	var data, err = ioutil.ReadFile(dataFile)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	json.Unmarshal(data, &c)
+	if err = json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	// the output sequences contain the ZWJ and variation selectors the keys don't have
+	var outputs = map[string]string{}
 	for k, v := range c {
-		var codePointStr string
-		for _, codePoint := range strings.Split(k, "-") {
-			if code, err := strconv.ParseInt(codePoint, 16, 32); err == nil {
-				codePointStr += string(rune(code))
-			}
+		if v.CodePoints.Output != "" {
+			outputs[k] = parseCodePoints(v.CodePoints.Output)
+		} else {
+			outputs[k] = parseCodePoints(k)
+		}
+		addEmojiOutput(outputs[k])
+	}
+	for k, v := range c {
+		var codePointStr = outputs[k]
+		for _, gender := range v.Genders {
+			addEmojiGender(codePointStr, outputs[gender])
+		}
+		for i, diversity := range v.Diversities {
+			addEmojiDiversity(codePointStr, outputs[diversity], i+1)
+		}
+		if v.Diversity != "" {
+			// skin tone variants are picked from the variants of their base emojis
+			continue
 		}
 		for _, ascii := range v.ASCII {
 			InsertTrie(trie, ascii, codePointStr)
@@ -55,12 +118,193 @@ func loadEmojiOne(dataFile string) (*TrieNode, error) {
 		for _, keyword := range v.Keywords {
 			InsertTrie(trie, keyword, codePointStr)
 		}
+		if shortname := strings.Trim(v.Shortname, ":"); shortname != "" {
+			InsertTrie(trie, shortname, codePointStr)
+			emojiShortnames[codePointStr] = shortname
+		}
+	}
+	return nil
+}
+
+// getEmojiVariants returns an emoji followed by its skin tone and gender variants
+func getEmojiVariants(codePoint string) []string {
+	var base = codePoint
+	if root, found := emojiBases[codePoint]; found {
+		base = root
+	}
+	var variants = []string{base}
+	var addVariants = func(codePoint string) {
+		for _, variant := range emojiDiversities[codePoint] {
+			if variant != "" {
+				variants = append(variants, variant)
+			}
+		}
+	}
+	addVariants(base)
+	for _, gender := range emojiGenders[base] {
+		variants = append(variants, gender)
+		addVariants(gender)
+	}
+	return variants
+}
+
+// applySkinTone returns the variant of an emoji in a skin tone, if there is one
+func applySkinTone(codePoint string, skinTone int) string {
+	if tones := emojiDiversities[codePoint]; skinTone > 0 && skinTone <= len(tones) && tones[skinTone-1] != "" {
+		return tones[skinTone-1]
+	}
+	return codePoint
+}
+
+type emojiUsage struct {
+	count    int
+	lastUsed int64
+}
+
+// EmojiUsages counts the emojis committed in all the engines, which share one usage file
+type EmojiUsages struct {
+	sync.RWMutex
+	usage map[string]*emojiUsage
+	// the uses since the file was last saved, they are added to the counts of the file
+	// on save so that the uses saved meanwhile by another process are kept
+	uses map[string]*emojiUsage
+}
+
+var emojiUsages = NewEmojiUsages()
+
+func NewEmojiUsages() *EmojiUsages {
+	return &EmojiUsages{usage: map[string]*emojiUsage{}, uses: map[string]*emojiUsage{}}
+}
+
+func (u *EmojiUsages) GetCount(codePoint string) int {
+	u.RLock()
+	defer u.RUnlock()
+	if e := u.usage[codePoint]; e != nil {
+		return e.count
+	}
+	return 0
+}
+
+// Use records that an emoji has been committed
+func (u *EmojiUsages) Use(codePoint string) {
+	u.Lock()
+	defer u.Unlock()
+	var now = time.Now().Unix()
+	for _, usage := range []map[string]*emojiUsage{u.usage, u.uses} {
+		var e = usage[codePoint]
+		if e == nil {
+			e = &emojiUsage{}
+			usage[codePoint] = e
+		}
+		e.count++
+		e.lastUsed = now
+	}
+}
+
+// Recent returns at most limit emojis, the most recently used first
+func (u *EmojiUsages) Recent(limit int) []string {
+	u.RLock()
+	defer u.RUnlock()
+	var codePoints []string
+	for cp := range u.usage {
+		codePoints = append(codePoints, cp)
+	}
+	sort.Slice(codePoints, func(i, j int) bool {
+		var ui, uj = u.usage[codePoints[i]], u.usage[codePoints[j]]
+		if ui.lastUsed != uj.lastUsed {
+			return ui.lastUsed > uj.lastUsed
+		}
+		return codePoints[i] < codePoints[j]
+	})
+	if len(codePoints) > limit {
+		codePoints = codePoints[:limit]
+	}
+	return codePoints
+}
+
+// LoadFromFile reads the `emoji count last-used` lines of the usage file,
+// the uses which haven't been saved yet are added to them
+func (u *EmojiUsages) LoadFromFile(fileName string) error {
+	usage, err := readEmojiUsage(fileName)
+	if err != nil {
+		return err
 	}
-	return trie, nil
+	u.Lock()
+	defer u.Unlock()
+	u.usage = mergeEmojiUsage(usage, u.uses)
+	return nil
+}
+
+// SaveToFile adds the uses since the last save to the usage file
+func (u *EmojiUsages) SaveToFile(fileName string) error {
+	u.Lock()
+	defer u.Unlock()
+	if len(u.uses) == 0 {
+		return nil
+	}
+	usage, err := readEmojiUsage(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	usage = mergeEmojiUsage(usage, u.uses)
+	var sb strings.Builder
+	for cp, e := range usage {
+		sb.WriteString(fmt.Sprintf("%s %d %d\n", cp, e.count, e.lastUsed))
+	}
+	if err := ioutil.WriteFile(fileName, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	u.usage = usage
+	u.uses = map[string]*emojiUsage{}
+	return nil
+}
+
+func readEmojiUsage(fileName string) (map[string]*emojiUsage, error) {
+	var usage = map[string]*emojiUsage{}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return usage, err
+	}
+	defer f.Close()
+	rd := bufio.NewReader(f)
+	for {
+		line, _, err := rd.ReadLine()
+		if err != nil {
+			break
+		}
+		var list = strings.Fields(string(line))
+		if len(list) != 3 {
+			continue
+		}
+		var count, _ = strconv.Atoi(list[1])
+		var lastUsed, _ = strconv.ParseInt(list[2], 10, 64)
+		usage[list[0]] = &emojiUsage{count: count, lastUsed: lastUsed}
+	}
+	return usage, nil
+}
+
+// mergeEmojiUsage adds the uses to the usage read from the file
+func mergeEmojiUsage(usage, uses map[string]*emojiUsage) map[string]*emojiUsage {
+	for cp, use := range uses {
+		var e = usage[cp]
+		if e == nil {
+			e = &emojiUsage{}
+			usage[cp] = e
+		}
+		e.count += use.count
+		if use.lastUsed > e.lastUsed {
+			e.lastUsed = use.lastUsed
+		}
+	}
+	return usage
 }
 
 type EmojiEngine struct {
-	keys []rune
+	keys     []rune
+	variants []string
+	SkinTone int
+	// Composer turns the typed keys into Vietnamese words, e.g. `cuwowif` into `cười`
+	Composer bamboo.IEngine
 }
 
 func NewEmojiEngine() *EmojiEngine {
@@ -73,6 +317,56 @@ func (be *EmojiEngine) MatchString(s string) bool {
 	return lookup != nil
 }
 
+// getComposedString returns the typed keys composed word by word with the Composer
+func (be *EmojiEngine) getComposedString() string {
+	var raw = string(be.keys)
+	if be.Composer == nil {
+		return raw
+	}
+	var words = strings.Split(raw, " ")
+	for i, word := range words {
+		be.Composer.Reset()
+		be.Composer.ProcessString(word, bamboo.VietnameseMode)
+		words[i] = be.Composer.GetProcessedString(bamboo.VietnameseMode)
+	}
+	be.Composer.Reset()
+	return strings.Join(words, " ")
+}
+
+// GetQueryString returns the typed keys, or their Vietnamese composition if only the latter matches
+func (be *EmojiEngine) GetQueryString() string {
+	var raw = string(be.keys)
+	if be.MatchString(raw) {
+		return raw
+	}
+	if composed := be.getComposedString(); be.MatchString(composed) {
+		return composed
+	}
+	return raw
+}
+
+// HasMatch reports whether the typed keys or their Vietnamese composition match any emoji
+func (be *EmojiEngine) HasMatch() bool {
+	return be.MatchString(string(be.keys)) || be.MatchString(be.getComposedString())
+}
+
+// getMatchQuality ranks an exact shortname over a shortname prefix over a keyword
+func getMatchQuality(codePoint, s string) int {
+	var shortname, found = emojiShortnames[codePoint]
+	if !found {
+		// the skin tone and gender variants are matched by the shortnames of their base emojis
+		shortname = emojiShortnames[emojiBases[codePoint]]
+	}
+	if shortname == s {
+		return 2
+	}
+	if strings.HasPrefix(shortname, s) {
+		return 1
+	}
+	return 0
+}
+
+// Filter returns the emojis matching s, the most used ones first, then by match quality
 func (be *EmojiEngine) Filter(s string) []string {
 	var codePoints []string
 	var keys []string
@@ -82,16 +376,38 @@ func (be *EmojiEngine) Filter(s string) []string {
 	}
 	var names = byString(keys)
 	sort.Sort(names)
+	var found = map[string]bool{}
 	for _, name := range names {
 		var cps = byString(strings.Split(lookup[name], ":"))
 		sort.Sort(cps)
 		for _, cp := range cps {
-			codePoints = append(codePoints, cp)
+			cp = applySkinTone(cp, be.SkinTone)
+			if !found[cp] {
+				found[cp] = true
+				codePoints = append(codePoints, cp)
+			}
 		}
 	}
+	sort.SliceStable(codePoints, func(i, j int) bool {
+		var ci, cj = emojiUsages.GetCount(codePoints[i]), emojiUsages.GetCount(codePoints[j])
+		if ci != cj {
+			return ci > cj
+		}
+		return getMatchQuality(codePoints[i], s) > getMatchQuality(codePoints[j], s)
+	})
 	return codePoints
 }
 
+// Use records that an emoji has been committed
+func (be *EmojiEngine) Use(codePoint string) {
+	emojiUsages.Use(codePoint)
+}
+
+// Recent returns at most limit emojis, the most recently used first
+func (be *EmojiEngine) Recent(limit int) []string {
+	return emojiUsages.Recent(limit)
+}
+
 func (be *EmojiEngine) ProcessKey(key rune) {
 	be.keys = append(be.keys, key)
 }
@@ -102,10 +418,48 @@ func (be *EmojiEngine) GetRawString() string {
 
 func (be *EmojiEngine) Reset() {
 	be.keys = nil
+	be.variants = nil
+}
+
+// ShowVariants makes Query return the skin tone and gender variants of an emoji,
+// it returns false if the emoji has no variant
+func (be *EmojiEngine) ShowVariants(codePoint string) bool {
+	var variants = getEmojiVariants(codePoint)
+	if len(variants) < 2 {
+		return false
+	}
+	be.variants = variants
+	return true
+}
+
+func (be *EmojiEngine) HideVariants() {
+	be.variants = nil
 }
 
+func (be *EmojiEngine) IsShowingVariants() bool {
+	return be.variants != nil
+}
+
+// Query returns the emojis matching the typed keys, or the recently used ones if only ":" is typed
 func (be *EmojiEngine) Query() []string {
-	return be.Filter(string(be.keys))
+	if be.variants != nil {
+		return be.variants
+	}
+	if string(be.keys) == ":" {
+		if recent := be.Recent(MaxRecentEmojis); len(recent) > 0 {
+			return recent
+		}
+	}
+	var raw = string(be.keys)
+	var codePoints = be.Filter(raw)
+	if composed := be.getComposedString(); composed != raw {
+		for _, cp := range be.Filter(composed) {
+			if !inStringList(codePoints, cp) {
+				codePoints = append(codePoints, cp)
+			}
+		}
+	}
+	return codePoints
 }
 
 func (be *EmojiEngine) RemoveLastKey() {
@@ -0,0 +1,270 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"ibus-bamboo/config"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	zeroWidthJoiner   = '‍'
+	variationSelector = '️'
+)
+
+// an emojiLoader adds the emojis of a data file to the trie and the emoji maps
+type emojiLoader func(trie *TrieNode, dataFile string) error
+
+// the loaders are chosen by file extensions, the annotations are loaded last
+// so that they could be attached to the emojis of the other files
+var emojiLoaders = []struct {
+	ext    string
+	loader emojiLoader
+}{
+	{".json", loadEmojiOneFile},
+	{".txt", loadEmojiTestFile},
+	{".xml", loadCLDRAnnotationsFile},
+}
+
+// loadEmojis merges the emojis of the data files, the files which fail to load are skipped
+func loadEmojis(dataFiles ...string) (*TrieNode, error) {
+	var trie = NewTrie()
+	emojiShortnames = map[string]string{}
+	emojiDiversities = map[string][]string{}
+	emojiGenders = map[string][]string{}
+	emojiBases = map[string]string{}
+	emojiOutputs = map[string]string{}
+	var nLoaded int
+	var lastErr error
+	for _, l := range emojiLoaders {
+		for _, dataFile := range dataFiles {
+			if strings.ToLower(filepath.Ext(dataFile)) != l.ext {
+				continue
+			}
+			if err := l.loader(trie, dataFile); err != nil {
+				log.Printf("Failed to load emojis from %s: %s\n", dataFile, err)
+				lastErr = err
+				continue
+			}
+			nLoaded++
+		}
+	}
+	if nLoaded == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no emoji data file in %v", dataFiles)
+		}
+		return nil, lastErr
+	}
+	// a variant of a gender variant is derived from the base emoji of both
+	for variant, base := range emojiBases {
+		if root, found := emojiBases[base]; found {
+			emojiBases[variant] = root
+		}
+	}
+	return trie, nil
+}
+
+// getEmojiDataFiles returns the bundled emoji data followed by the files the user dropped
+// into the emoji directory of the config dir
+func getEmojiDataFiles(engineName string) []string {
+	var dataFiles = []string{DictEmojiOne, DictEmojiVi}
+	var dir = config.GetEmojiDataDir(engineName)
+	if files, err := ioutil.ReadDir(dir); err == nil {
+		var names []string
+		for _, f := range files {
+			if !f.IsDir() {
+				names = append(names, f.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dataFiles = append(dataFiles, filepath.Join(dir, name))
+		}
+	}
+	return dataFiles
+}
+
+func removeVariationSelectors(codePoint string) string {
+	return strings.Replace(codePoint, string(variationSelector), "", -1)
+}
+
+func addEmojiOutput(codePoint string) {
+	emojiOutputs[removeVariationSelectors(codePoint)] = codePoint
+}
+
+// getEmojiOutput returns the sequence committed for an emoji which may lack its variation selectors
+func getEmojiOutput(codePoint string) string {
+	if output, found := emojiOutputs[removeVariationSelectors(codePoint)]; found {
+		return output
+	}
+	return codePoint
+}
+
+func addEmojiDiversity(base, variant string, skinTone int) {
+	if skinTone < 1 || skinTone > len(emojiSkinToneModifiers) {
+		return
+	}
+	if emojiDiversities[base] == nil {
+		emojiDiversities[base] = make([]string, len(emojiSkinToneModifiers))
+	}
+	emojiDiversities[base][skinTone-1] = variant
+	emojiBases[variant] = base
+}
+
+func addEmojiGender(base, variant string) {
+	if !inStringList(emojiGenders[base], variant) {
+		emojiGenders[base] = append(emojiGenders[base], variant)
+	}
+	emojiBases[variant] = base
+}
+
+// getSkinTone returns the tone of the only Fitzpatrick modifier of an emoji and the emoji without it,
+// the tone is -1 if there are several modifiers
+func getSkinTone(codePoint string) (int, string) {
+	var skinTone = 0
+	var base []rune
+	for _, c := range codePoint {
+		if c >= 0x1F3FB && c <= 0x1F3FF {
+			if skinTone != 0 {
+				return -1, codePoint
+			}
+			skinTone = int(c-0x1F3FB) + 1
+			continue
+		}
+		base = append(base, c)
+	}
+	return skinTone, string(base)
+}
+
+// getGenderBase returns the emoji without its ZWJ gender sign, e.g. 👮‍♀️ is derived from 👮
+func getGenderBase(codePoint string) (string, bool) {
+	var chars = []rune(removeVariationSelectors(codePoint))
+	var n = len(chars)
+	if n < 3 || chars[n-2] != zeroWidthJoiner || (chars[n-1] != '♀' && chars[n-1] != '♂') {
+		return "", false
+	}
+	return string(chars[:n-2]), true
+}
+
+// loadEmojiTestFile adds the fully-qualified emojis of Unicode's emoji-test.txt, lines are like
+// 1F44B 1F3FB ; fully-qualified # 👋🏻 E1.0 waving hand: light skin tone
+func loadEmojiTestFile(trie *TrieNode, dataFile string) error {
+	f, err := os.Open(dataFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var names = map[string]string{}
+	var codePoints []string
+	rd := bufio.NewReader(f)
+	for {
+		line, _, err := rd.ReadLine()
+		if err != nil {
+			break
+		}
+		var s = string(line)
+		if len(s) == 0 || strings.HasPrefix(s, "#") {
+			continue
+		}
+		var list = strings.SplitN(s, ";", 2)
+		if len(list) != 2 || !strings.HasPrefix(strings.TrimSpace(list[1]), "fully-qualified") {
+			continue
+		}
+		var codePoint = parseCodePoints(strings.Join(strings.Fields(list[0]), "-"))
+		var name string
+		if i := strings.Index(list[1], "#"); i > 0 {
+			// the comment is the emoji, its version and its name
+			var comment = strings.Fields(list[1][i+1:])
+			if len(comment) > 2 {
+				name = strings.Join(comment[2:], " ")
+			}
+		}
+		names[codePoint] = name
+		codePoints = append(codePoints, codePoint)
+		addEmojiOutput(codePoint)
+	}
+	for _, codePoint := range codePoints {
+		var skinTone, base = getSkinTone(codePoint)
+		if skinTone < 0 {
+			continue
+		}
+		if skinTone > 0 {
+			addEmojiDiversity(getEmojiOutput(base), codePoint, skinTone)
+			continue
+		}
+		if genderBase, ok := getGenderBase(codePoint); ok {
+			if _, found := emojiOutputs[genderBase]; found {
+				addEmojiGender(getEmojiOutput(genderBase), codePoint)
+			}
+		}
+		var name = strings.ToLower(names[codePoint])
+		if name == "" {
+			continue
+		}
+		InsertTrie(trie, name, codePoint)
+		var shortname = strings.NewReplacer(" ", "_", ":", "", ",", "", "’", "", "“", "", "”", "").Replace(name)
+		InsertTrie(trie, shortname, codePoint)
+		if _, found := emojiShortnames[codePoint]; !found {
+			emojiShortnames[codePoint] = shortname
+		}
+	}
+	return nil
+}
+
+type cldrAnnotations struct {
+	Annotations []struct {
+		CP   string `xml:"cp,attr"`
+		Type string `xml:"type,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"annotations>annotation"`
+}
+
+// loadCLDRAnnotationsFile adds the keywords and names of a CLDR annotation file, e.g. vi.xml
+func loadCLDRAnnotationsFile(trie *TrieNode, dataFile string) error {
+	var data, err = ioutil.ReadFile(dataFile)
+	if err != nil {
+		return err
+	}
+	var c cldrAnnotations
+	if err = xml.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	for _, a := range c.Annotations {
+		var codePoint = getEmojiOutput(a.CP)
+		for _, keyword := range strings.Split(a.Text, "|") {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+				InsertTrie(trie, keyword, codePoint)
+				// the unaccented keywords let "cuoi" find the emojis of "cười"
+				if unaccented := removeDiacritics(keyword); unaccented != keyword {
+					InsertTrie(trie, unaccented, codePoint)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -19,7 +19,12 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/BambooEngine/bamboo-core"
 )
 
 func TestEmojiFindResult(t *testing.T) {
@@ -55,3 +60,147 @@ func TestFilterEmoji(t *testing.T) {
 		t.Errorf("Filtering emojo `grin`, expected %v got %v", true, inStringList(grinnings3, "😀"))
 	}
 }
+
+func TestEmojiRanking(t *testing.T) {
+	emojiTrie, _ = loadEmojiOne(DictEmojiOne)
+	defer func(usages *EmojiUsages) { emojiUsages = usages }(emojiUsages)
+	emojiUsages = NewEmojiUsages()
+	var be = NewEmojiEngine()
+	var smiles = be.Filter("smile")
+	if len(smiles) < 2 || smiles[0] != "😄" {
+		t.Errorf("Filtering emoji `smile`, expected 😄 first, got %v", smiles)
+	}
+	be.Use("😊")
+	smiles = be.Filter("smile")
+	if smiles[0] != "😊" {
+		t.Errorf("Filtering emoji `smile` after using 😊, expected 😊 first, got %v", smiles[0])
+	}
+	be.ProcessKey(':')
+	if recent := be.Query(); len(recent) != 1 || recent[0] != "😊" {
+		t.Errorf("Querying emoji `:`, expected the recently used 😊, got %v", recent)
+	}
+	var wave = applySkinTone("👋", 3)
+	if wave == "👋" || getMatchQuality(wave, "wave") != 2 {
+		t.Errorf("Matching %s with `wave`, expected the shortname of 👋 to match exactly", wave)
+	}
+	be.SkinTone = 3
+	if waves := be.Filter("wave"); len(waves) == 0 || waves[0] != wave {
+		t.Errorf("Filtering emoji `wave` with skin tone 3, expected %s first, got %v", wave, waves)
+	}
+}
+
+func TestEmojiUsages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emoji-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var fileName = filepath.Join(dir, "emoji.text")
+	var u1, u2 = NewEmojiUsages(), NewEmojiUsages()
+	u1.Use("😊")
+	u2.Use("😊")
+	u2.Use("😄")
+	if err = u1.SaveToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if err = u2.SaveToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	var u = NewEmojiUsages()
+	u.LoadFromFile(fileName)
+	if u.GetCount("😊") != 2 || u.GetCount("😄") != 1 {
+		t.Errorf("Saving the usages of two engines, expected 😊 2 and 😄 1, got %d and %d", u.GetCount("😊"), u.GetCount("😄"))
+	}
+}
+
+func TestEmojiVariants(t *testing.T) {
+	emojiTrie, _ = loadEmojiOne(DictEmojiOne)
+	var variants = getEmojiVariants("👮")
+	if len(variants) != 18 || variants[0] != "👮" || variants[1] != "👮🏻" {
+		t.Errorf("Getting the variants of 👮, expected 18 variants, got %v", variants)
+	}
+	if !inStringList(variants, "👮‍♂️") || !inStringList(variants, "👮🏽‍♀️") {
+		t.Errorf("Getting the variants of 👮, expected the gender variants, got %v", variants)
+	}
+	if v := getEmojiVariants("👮🏽‍♀️"); len(v) != 18 {
+		t.Errorf("Getting the variants of a variant, expected the variants of its base, got %v", v)
+	}
+	var be = NewEmojiEngine()
+	be.SkinTone = 3
+	if hands := be.Filter("open_hands"); len(hands) == 0 || hands[0] != "👐🏽" {
+		t.Errorf("Filtering emoji `open_hands` with skin tone 3, expected 👐🏽, got %v", hands)
+	}
+}
+
+func TestLoadEmojis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emoji")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var emojiTest = filepath.Join(dir, "emoji-test.txt")
+	var annotations = filepath.Join(dir, "vi.xml")
+	ioutil.WriteFile(emojiTest, []byte(`# group: Smileys & Emotion
+1FAE0                                                  ; fully-qualified     # 🫠 E14.0 melting face
+1FAF6                                                  ; fully-qualified     # 🫶 E14.0 heart hands
+1FAF6 1F3FD                                            ; fully-qualified     # 🫶🏽 E14.0 heart hands: medium skin tone
+263A                                                   ; unqualified         # ☺ E0.6 smiling face
+`), 0644)
+	ioutil.WriteFile(annotations, []byte(`<ldml><annotations>
+<annotation cp="😄">cười | vui vẻ</annotation>
+<annotation cp="🫠" type="tts">mặt tan chảy</annotation>
+</annotations></ldml>`), 0644)
+	emojiTrie, err = loadEmojis(DictEmojiOne, emojiTest, annotations, filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var be = NewEmojiEngine()
+	if faces := be.Filter("melting_face"); len(faces) == 0 || faces[0] != "🫠" {
+		t.Errorf("Filtering emoji `melting_face` from emoji-test.txt, expected 🫠, got %v", faces)
+	}
+	if faces := be.Filter("mặt tan"); len(faces) == 0 || faces[0] != "🫠" {
+		t.Errorf("Filtering emoji `mặt tan` from vi.xml, expected 🫠, got %v", faces)
+	}
+	if smiles := be.Filter("vui"); !inStringList(smiles, "😄") {
+		t.Errorf("Filtering emoji `vui` from vi.xml, expected 😄, got %v", smiles)
+	}
+	if tone := applySkinTone("🫶", 3); tone != "🫶🏽" {
+		t.Errorf("Applying skin tone 3 to 🫶, expected 🫶🏽, got %v", tone)
+	}
+	if _, err = loadEmojis(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Loading emojis from missing files, expected an error")
+	}
+	emojiTrie, _ = loadEmojiOne(DictEmojiOne)
+}
+
+func TestVietnameseEmojiKeywords(t *testing.T) {
+	emojiTrie, _ = loadEmojis(DictEmojiOne, DictEmojiVi)
+	defer func() { emojiTrie, _ = loadEmojiOne(DictEmojiOne) }()
+	var be = NewEmojiEngine()
+	be.Composer = bamboo.NewEngine(bamboo.ParseInputMethod(bamboo.GetInputMethodDefinitions(), "Telex"), bamboo.EstdFlags)
+	for _, key := range "cuwowif" {
+		be.ProcessKey(key)
+	}
+	if q := be.GetQueryString(); q != "cười" {
+		t.Errorf("Composing the emoji query `cuwowif`, expected cười, got %s", q)
+	}
+	if !inStringList(be.Query(), "😂") {
+		t.Errorf("Querying emoji `cười`, expected 😂, got %v", be.Query())
+	}
+	if faces := be.Filter("cuoi"); !inStringList(faces, "😂") {
+		t.Errorf("Filtering emoji `cuoi`, expected 😂, got %v", faces)
+	}
+	if cats := be.Filter("meo"); !inStringList(cats, "🐈") {
+		t.Errorf("Filtering emoji `meo`, expected 🐈, got %v", cats)
+	}
+	if hearts := be.Filter("tim"); !inStringList(hearts, "❤️") {
+		t.Errorf("Filtering emoji `tim`, expected ❤️, got %v", hearts)
+	}
+	be.Reset()
+	for _, key := range "cool" {
+		be.ProcessKey(key)
+	}
+	if q := be.GetQueryString(); q != "cool" {
+		t.Errorf("Composing the emoji query `cool`, expected the English keyword, got %s", q)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/BambooEngine/bamboo-core"
 	ibus "github.com/BambooEngine/goibus"
@@ -41,35 +42,59 @@ type IBusBambooEngine struct {
 	preeditor              bamboo.IEngine
 	engineName             string
 	config                 *config.Config
+	globalConfig           *config.Config
 	propList               *ibus.PropList
 	englishMode            bool
 	macroTable             *MacroTable
 	wmClasses              string
 	isInputModeLTOpened    bool
 	isEmojiLTOpened        bool
+	isUnicodeLTOpened      bool
 	isInHexadecimal        bool
+	hexBuffer              string
+	isCandidateLTOpened    bool
+	isCandidateBrowsed     bool
+	isMacroCandidates      bool
+	macroPrefix            string
+	isSpellingCandidates   bool
+	spellingCheckedText    string
+	spellingWordBreak      string
+	convertedKeys          string
+	retypedKeys            string
+	lastCommittedWord      *committedWord
 	emojiLookupTable       *ibus.LookupTable
 	inputModeLookupTable   *ibus.LookupTable
+	unicodeLookupTable     *ibus.LookupTable
+	unicodeQuery           []rune
+	unicodeCandidates      []rune
+	candidateLookupTable   *ibus.LookupTable
+	candidates             []string
+	previousWord           string
 	capabilities           uint32
+	contentPurpose         uint32
+	contentHints           uint32
+	isSentenceStart        bool
 	keyPressDelay          int
 	nFakeBackSpace         int32
 	isFirstTimeSendingBS   bool
 	emoji                  *EmojiEngine
 	isSurroundingTextReady bool
+	textBeforeCursor       []rune
 	lastKeyWithShift       bool
 	lastCommitText         int64
-	// restore key strokes by pressing Shift + Space
-	shouldRestoreKeyStrokes bool
+	focusSerial            uint32
+	macroCommands          sync.WaitGroup
 	// enqueue key strokes to process later
 	shouldEnqueuKeyStrokes bool
 }
 
 func NewIbusBambooEngine(name string, cfg *config.Config, base IEngine, preeditor bamboo.IEngine) *IBusBambooEngine {
 	return &IBusBambooEngine{
-		engineName: name,
-		IEngine:    base,
-		preeditor:  preeditor,
-		config:     cfg,
+		engineName:   name,
+		IEngine:      base,
+		preeditor:    preeditor,
+		config:       cfg,
+		globalConfig: cfg,
 	}
 }
 
@@ -102,6 +127,10 @@ func (e *IBusBambooEngine) ProcessKeyEvent(keyVal uint32, keyCode uint32, state
 	if ret, retValue := e.processShortcutKey(keyVal, keyCode, state); ret {
 		return retValue, nil
 	}
+	if e.isContentTypeBypassed() {
+		return false, nil
+	}
+	keyVal = e.capitalizeSentence(keyVal, state)
 	if e.inBackspaceWhiteList() {
 		return e.bsProcessKeyEvent(keyVal, keyCode, state)
 	}
@@ -110,33 +139,58 @@ func (e *IBusBambooEngine) ProcessKeyEvent(keyVal uint32, keyCode uint32, state
 
 func (e *IBusBambooEngine) FocusIn() *dbus.Error {
 	log.Print("FocusIn.")
+	atomic.AddUint32(&e.focusSerial, 1)
 	var latestWm = e.getLatestWmClass()
 	e.checkWmClass(latestWm)
 	e.RegisterProperties(e.propList)
 	e.RequireSurroundingText()
 	if e.isShortcutKeyEnable(KSEmojiDialog) && emojiTrie != nil && len(emojiTrie.Children) == 0 {
 		var err error
-		emojiTrie, err = loadEmojiOne(DictEmojiOne)
+		emojiTrie, err = loadEmojis(getEmojiDataFiles(e.engineName)...)
 		if err != nil {
 			panic(fmt.Sprintf("failed to load emojiTrie from %s: %s", DictEmojiOne, err))
 		}
 	}
-	if e.config.IBflags&config.IBspellCheckWithDicts != 0 && len(dictionary) == 0 {
-		dictionary, _ = loadDictionary(DictVietnameseCm)
+	if e.config.IBflags&config.IBspellCheckWithDicts != 0 && !isDictionaryLoaded() {
+		loadDictionaries(e.engineName)
+	}
+	if e.config.IBflags&config.IBenglishProtection != 0 && len(englishWords) == 0 {
+		englishWords, _ = loadDictionary(DictEnglish)
+	}
+	if e.isLexiconEnabled() && lexicon.IsEmpty() {
+		loadLexicon(e.engineName)
 	}
+	exceptions.LoadFromFile(config.GetExceptionsPath(e.engineName))
+	e.previousWord = ""
+	e.textBeforeCursor = nil
 	fmt.Printf("WM_CLASS=(%s)\n", e.getWmClass())
 	return nil
 }
 
 func (e *IBusBambooEngine) FocusOut() *dbus.Error {
 	log.Print("FocusOut.")
+	atomic.AddUint32(&e.focusSerial, 1)
+	// the next focused client sends its own content type
+	if e.contentPurpose != IBusInputPurposeFreeForm || e.contentHints != 0 {
+		e.SetContentType(IBusInputPurposeFreeForm, 0)
+	}
+	if e.isLexiconEnabled() {
+		lexicon.SaveToFile(config.GetLexiconPath(e.engineName))
+	}
+	emojiUsages.SaveToFile(config.GetEmojiUsagePath(e.engineName))
 	return nil
 }
 
 func (e *IBusBambooEngine) Reset() *dbus.Error {
 	fmt.Print("Reset.\n")
+	if e.isInHexadecimal {
+		e.closeHexadecimalInput()
+	}
 	if e.checkInputMode(config.PreeditIM) {
 		e.preeditor.Reset()
+		if e.isCandidateLTOpened {
+			e.closeCandidates()
+		}
 	}
 	return nil
 }
@@ -154,6 +208,10 @@ func (e *IBusBambooEngine) Disable() *dbus.Error {
 
 // @method(in_signature="vuu")
 func (e *IBusBambooEngine) SetSurroundingText(text dbus.Variant, cursorPos uint32, anchorPos uint32) *dbus.Error {
+	if e.checkInputMode(config.PreeditIM) && e.config.IBflags&config.IBreconversion != 0 {
+		e.updateTextBeforeCursor(text, cursorPos, anchorPos)
+		return nil
+	}
 	if !e.isSurroundingTextReady {
 		//fmt.Println("Surrounding Text is not ready yet.")
 		return nil
@@ -190,9 +248,15 @@ func (e *IBusBambooEngine) PageUp() *dbus.Error {
 	if e.isEmojiLTOpened && e.emojiLookupTable.PageUp() {
 		e.updateEmojiLookupTable()
 	}
+	if e.isUnicodeLTOpened && e.unicodeLookupTable.PageUp() {
+		e.updateUnicodeLookupTable()
+	}
 	if e.isInputModeLTOpened && e.inputModeLookupTable.PageUp() {
 		e.updateInputModeLT()
 	}
+	if e.isCandidateLTOpened && e.candidateLookupTable.PageUp() {
+		e.updateCandidateLookupTable()
+	}
 	return nil
 }
 
@@ -200,9 +264,15 @@ func (e *IBusBambooEngine) PageDown() *dbus.Error {
 	if e.isEmojiLTOpened && e.emojiLookupTable.PageDown() {
 		e.updateEmojiLookupTable()
 	}
+	if e.isUnicodeLTOpened && e.unicodeLookupTable.PageDown() {
+		e.updateUnicodeLookupTable()
+	}
 	if e.isInputModeLTOpened && e.inputModeLookupTable.PageDown() {
 		e.updateInputModeLT()
 	}
+	if e.isCandidateLTOpened && e.candidateLookupTable.PageDown() {
+		e.updateCandidateLookupTable()
+	}
 	return nil
 }
 
@@ -210,9 +280,15 @@ func (e *IBusBambooEngine) CursorUp() *dbus.Error {
 	if e.isEmojiLTOpened && e.emojiLookupTable.CursorUp() {
 		e.updateEmojiLookupTable()
 	}
+	if e.isUnicodeLTOpened && e.unicodeLookupTable.CursorUp() {
+		e.updateUnicodeLookupTable()
+	}
 	if e.isInputModeLTOpened && e.inputModeLookupTable.CursorUp() {
 		e.updateInputModeLT()
 	}
+	if e.isCandidateLTOpened && e.candidateLookupTable.CursorUp() {
+		e.updateCandidateLookupTable()
+	}
 	return nil
 }
 
@@ -220,9 +296,15 @@ func (e *IBusBambooEngine) CursorDown() *dbus.Error {
 	if e.isEmojiLTOpened && e.emojiLookupTable.CursorDown() {
 		e.updateEmojiLookupTable()
 	}
+	if e.isUnicodeLTOpened && e.unicodeLookupTable.CursorDown() {
+		e.updateUnicodeLookupTable()
+	}
 	if e.isInputModeLTOpened && e.inputModeLookupTable.CursorDown() {
 		e.updateInputModeLT()
 	}
+	if e.isCandidateLTOpened && e.candidateLookupTable.CursorDown() {
+		e.updateCandidateLookupTable()
+	}
 	return nil
 }
 
@@ -231,10 +313,17 @@ func (e *IBusBambooEngine) CandidateClicked(index uint32, button uint32, state u
 		e.commitEmojiCandidate()
 		e.closeEmojiCandidates()
 	}
+	if e.isUnicodeLTOpened && e.unicodeLookupTable.SetCursorPos(index) {
+		e.commitUnicodeCandidate()
+		e.closeUnicodeCandidates()
+	}
 	if e.isInputModeLTOpened && e.inputModeLookupTable.SetCursorPos(index) {
 		e.commitInputModeCandidate()
 		e.closeInputModeCandidates()
 	}
+	if e.isCandidateLTOpened && e.candidateLookupTable.SetCursorPos(index) {
+		e.commitCandidate()
+	}
 	return nil
 }
 
@@ -248,6 +337,14 @@ func (e *IBusBambooEngine) SetCursorLocation(x int32, y int32, w int32, h int32)
 }
 
 func (e *IBusBambooEngine) SetContentType(purpose uint32, hints uint32) *dbus.Error {
+	log.Printf("SetContentType: purpose=%d hints=%d\n", purpose, hints)
+	var wasSpellCheckDisabled = e.isSpellCheckDisabledByContentType()
+	e.contentPurpose = purpose
+	e.contentHints = hints
+	e.isSentenceStart = true
+	if wasSpellCheckDisabled != e.isSpellCheckDisabledByContentType() {
+		e.applyProfile()
+	}
 	return nil
 }
 
@@ -263,19 +360,24 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 	}
 	if propName == PropKeyConfiguration {
 		ui.OpenGUI(e.engineName)
-		e.config = config.LoadConfig(e.engineName)
+		e.globalConfig = config.LoadConfig(e.engineName)
+		e.applyProfile()
 		return nil
 	}
 	if propName == PropKeyInputModeLookupTableShortcut {
 		ui.OpenGUI(e.engineName)
-		e.config = config.LoadConfig(e.engineName)
+		e.globalConfig = config.LoadConfig(e.engineName)
+		e.applyProfile()
 		return nil
 	}
 	if propName == PropKeyMacroTable {
 		ui.OpenGUI(e.engineName)
-		e.config = config.LoadConfig(e.engineName)
+		e.globalConfig = config.LoadConfig(e.engineName)
+		e.applyProfile()
 		return nil
 	}
+	// the properties change the global settings, the profile of the focused app is applied again afterwards
+	e.config = e.globalConfig
 
 	turnSpellChecking := func(on bool) {
 		if on {
@@ -323,7 +425,7 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 		if propState == ibus.PROP_STATE_CHECKED {
 			e.config.IBflags |= config.IBspellCheckWithDicts
 			turnSpellChecking(true)
-			dictionary, _ = loadDictionary(DictVietnameseCm)
+			loadDictionaries(e.engineName)
 		} else {
 			e.config.IBflags &= ^config.IBspellCheckWithDicts
 		}
@@ -344,6 +446,58 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 			e.config.IBflags &= ^config.IBnoUnderline
 		}
 	}
+	if propName == PropKeyWordCompletion {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= config.IBwordCompletion
+			if lexicon.IsEmpty() {
+				loadLexicon(e.engineName)
+			}
+		} else {
+			e.config.IBflags &= ^config.IBwordCompletion
+		}
+	}
+	if propName == PropKeyToneLessTyping {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= config.IBtoneLessTyping
+			if lexicon.IsEmpty() {
+				loadLexicon(e.engineName)
+			}
+		} else {
+			e.config.IBflags &= ^config.IBtoneLessTyping
+		}
+	}
+	if propName == PropKeyReconversion {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= config.IBreconversion
+		} else {
+			e.config.IBflags &= ^config.IBreconversion
+		}
+	}
+	if propName == PropKeyEnglishProtection {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= config.IBenglishProtection
+			englishWords, _ = loadDictionary(DictEnglish)
+			if lexicon.IsEmpty() {
+				loadLexicon(e.engineName)
+			}
+		} else {
+			e.config.IBflags &= ^config.IBenglishProtection
+		}
+	}
+	if propName == PropKeySpellingSuggestion {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= config.IBspellingSuggestion
+		} else {
+			e.config.IBflags &= ^config.IBspellingSuggestion
+		}
+	}
+	if propName == PropKeyMacroSuggestion {
+		if propState == ibus.PROP_STATE_CHECKED {
+			e.config.IBflags |= config.IBmacroSuggestion
+		} else {
+			e.config.IBflags &= ^config.IBmacroSuggestion
+		}
+	}
 	if propName == PropKeyPreeditElimination {
 		if propState == ibus.PROP_STATE_CHECKED {
 			e.config.IBflags |= config.IBpreeditElimination
@@ -374,12 +528,9 @@ func (e *IBusBambooEngine) PropertyActivate(propName string, propState uint32) *
 		e.config.InputMethod = propName
 	}
 	if propName != "-" {
-		config.SaveConfig(e.config, e.engineName)
+		config.SaveConfig(e.globalConfig, e.engineName)
 	}
-	e.propList = GetPropListByConfig(e.config)
-
-	var inputMethod = bamboo.ParseInputMethod(e.config.InputMethodDefinitions, e.config.InputMethod)
-	e.preeditor = bamboo.NewEngine(inputMethod, e.config.Flags)
+	e.applyProfile()
 	e.RegisterProperties(e.propList)
 	return nil
 }
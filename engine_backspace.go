@@ -34,6 +34,11 @@ import (
 const BACKSPACE_INTERVAL = 0
 
 func (e *IBusBambooEngine) bsProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) (bool, *dbus.Error) {
+	e.lastCommittedWord = nil
+	// the Backspaces sent to replace the text come back as key events
+	if e.getRawKeyLen() == 0 && len(keyPressChan) == 0 && (keyVal != IBusBackSpace || e.getFakeBackspace() == 0) {
+		e.checkBackspaceAfterConversion(keyVal)
+	}
 	if isMovementKey(keyVal) {
 		e.preeditor.Reset()
 		e.resetFakeBackspace()
@@ -111,6 +116,7 @@ func (e *IBusBambooEngine) keyPressHandler(keyVal, keyCode, state uint32) bool {
 	}
 	oldText := e.getPreeditString()
 	_, oldMacText := e.getMacroText()
+	e.lastCommittedWord = nil
 	if keyVal == IBusBackSpace {
 		if e.getRawKeyLen() > 0 {
 			if e.config.IBflags&config.IBautoNonVnRestore == 0 {
@@ -138,7 +144,13 @@ func (e *IBusBambooEngine) keyPressHandler(keyVal, keyCode, state uint32) bool {
 	}
 
 	isValidKey := isValidState(state) && e.isValidKeyVal(keyVal)
+	var keys, vietnamese = e.getProcessedString(bamboo.EnglishMode), e.getProcessedString(bamboo.VietnameseMode)
+	var typedKeys = e.getTypedKeys()
 	newText, isWordBreakRune := e.getCommitText(keyVal, keyCode, state)
+	if isWordBreakRune {
+		e.checkRetypedWord(newText, typedKeys)
+		e.rememberCommittedWord(newText, keys, vietnamese, keyVal, state)
+	}
 	if len(newText) > 0 {
 		if e.shouldAppendDeadKey(newText, oldText) {
 			fmt.Println("Append a deadkey")
@@ -206,6 +218,7 @@ func (e *IBusBambooEngine) updatePreviousTextInBatch(oldText, newText string, is
 		isValidKey := isValidState(state) && e.isValidKeyVal(keyVal)
 		if isValidKey {
 			var commitText, isWordBreakRune0 = e.getCommitText(keyVal, keyCode, state)
+			e.lastCommittedWord = nil
 			buffer[len(buffer)-1] = commitText
 			if isWordBreakRune0 {
 				buffer = append(buffer, "")
@@ -332,17 +345,32 @@ func (e *IBusBambooEngine) bsCommitText(rs []rune) {
 		return
 	}
 	if e.checkInputMode(config.ForwardAsCommitIM) {
-		log.Println("Forward as commit", string(rs))
-		for _, chr := range rs {
-			var keyVal = vnSymMapping[chr]
-			if keyVal == 0 {
-				keyVal = uint32(chr)
-			}
-			e.ForwardKeyEvent(keyVal, 0, 0)
-			e.ForwardKeyEvent(keyVal, 0, IBusReleaseMask)
+		if parts := splitMacroCommands(string(rs)); len(parts) > 1 {
+			e.runMacroCommands(parts, e.forwardAsCommit)
+			return
 		}
-		time.Sleep(time.Duration(len(rs)) * 5 * time.Millisecond)
+		e.forwardAsCommit(string(rs))
 		return
 	}
 	e.commitText(string(rs))
 }
+
+// forwardAsCommit types a text by forwarding the keys of its characters
+func (e *IBusBambooEngine) forwardAsCommit(text string) {
+	if text == "" {
+		return
+	}
+	text, nLeft := removeMacroCursor(insertMacroClipboard(text))
+	var rs = []rune(text)
+	log.Println("Forward as commit", string(rs))
+	defer e.moveCursorLeft(nLeft)
+	for _, chr := range rs {
+		var keyVal = vnSymMapping[chr]
+		if keyVal == 0 {
+			keyVal = uint32(chr)
+		}
+		e.ForwardKeyEvent(keyVal, 0, 0)
+		e.ForwardKeyEvent(keyVal, 0, IBusReleaseMask)
+	}
+	time.Sleep(time.Duration(len(rs)) * 5 * time.Millisecond)
+}
@@ -0,0 +1,348 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"ibus-bamboo/config"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/BambooEngine/bamboo-core"
+	ibus "github.com/BambooEngine/goibus"
+)
+
+const (
+	CandidateMaxPageSize = 9
+	MaxCompletionWords   = 50
+	MaxMacroPreviewLen   = 30
+)
+
+func loadLexicon(engineName string) {
+	if words, err := loadDictionary(DictVietnameseCm); err == nil {
+		lexicon.AddWords(words)
+	} else {
+		log.Println(err)
+	}
+	lexicon.LoadBaseFrequencies(DictVietnameseFreq)
+	lexicon.LoadFromFile(config.GetLexiconPath(engineName))
+}
+
+// the English protection counts the Vietnamese words too, so that the ones the user types are kept
+func (e *IBusBambooEngine) isLexiconEnabled() bool {
+	return e.config.IBflags&(config.IBwordCompletion|config.IBtoneLessTyping|config.IBspellingSuggestion|config.IBenglishProtection) != 0
+}
+
+func (e *IBusBambooEngine) getCompletionCandidates(text string) []string {
+	if text == "" {
+		return nil
+	}
+	for _, c := range text {
+		if !unicode.IsLetter(c) {
+			return nil
+		}
+	}
+	var words = lexicon.Complete(text, MaxCompletionWords)
+	for i, word := range words {
+		words[i] = applyCaseOf(text, word)
+	}
+	return words
+}
+
+// getRestorationCandidates returns the accented words for a text typed without diacritics
+func (e *IBusBambooEngine) getRestorationCandidates(text string) []string {
+	if text == "" {
+		return nil
+	}
+	var words = lexicon.Restore(text, e.previousWord, MaxCompletionWords)
+	for i, word := range words {
+		words[i] = applyCaseOf(text, word)
+	}
+	return words
+}
+
+// getMacroCandidates returns the macro keys starting with the typed text
+func (e *IBusBambooEngine) getMacroCandidates(text string) []string {
+	if text == "" || e.config.IBflags&config.IBmacroEnabled == 0 || e.macroTable == nil {
+		return nil
+	}
+	e.macroPrefix = text
+	return e.macroTable.GetKeysWithPrefix(text, MaxCompletionWords)
+}
+
+// applyMacroCase writes a macro key in the letter case of the typed prefix if the macros are
+// capitalized automatically, the expansion then follows the case typed
+func (e *IBusBambooEngine) applyMacroCase(key string) string {
+	if e.config.IBflags&config.IBautoCapitalizeMacro == 0 || e.macroPrefix == "" {
+		return key
+	}
+	return applyCaseOf(e.macroPrefix, key)
+}
+
+// getMacroPreview shows a macro key along with the beginning of its expansion
+func (e *IBusBambooEngine) getMacroPreview(key string) string {
+	var preview = []rune(strings.NewReplacer("\n", "⏎", "\t", " ").Replace(e.macroTable.GetText(key)))
+	if len(preview) > MaxMacroPreviewLen {
+		preview = append(preview[:MaxMacroPreviewLen-1], '…')
+	}
+	return e.applyMacroCase(key) + " → " + string(preview)
+}
+
+// applyCaseOf returns word written in the same letter case as the typed prefix
+func applyCaseOf(prefix, word string) string {
+	var chars = []rune(prefix)
+	if !unicode.IsUpper(chars[0]) {
+		return word
+	}
+	if len(chars) > 1 && determineMacroCase(prefix) == VnCaseAllCapital {
+		return strings.ToUpper(word)
+	}
+	var wordChars = []rune(word)
+	wordChars[0] = unicode.ToUpper(wordChars[0])
+	return string(wordChars)
+}
+
+func (e *IBusBambooEngine) updateCandidates(text string) {
+	var candidates []string
+	if e.config.IBflags&config.IBmacroSuggestion != 0 {
+		candidates = e.getMacroCandidates(text)
+	}
+	// the matching macros take the place of the words
+	e.isMacroCandidates = len(candidates) > 0
+	e.isSpellingCandidates = false
+	if !e.isMacroCandidates {
+		if e.config.IBflags&config.IBtoneLessTyping != 0 {
+			candidates = e.getRestorationCandidates(text)
+		} else if e.config.IBflags&config.IBwordCompletion != 0 {
+			candidates = e.getCompletionCandidates(text)
+		}
+	}
+	if len(candidates) == 0 {
+		if e.isCandidateLTOpened {
+			e.closeCandidates()
+		}
+		return
+	}
+	// the cursor shows up once the user starts browsing the candidates,
+	// restored words are committed on word breaks so the first one is always selected
+	e.showCandidates(candidates, e.config.IBflags&config.IBtoneLessTyping != 0 && !e.isMacroCandidates)
+}
+
+// getSpellingCandidates returns the valid words nearest to the pre-edit text,
+// or nil if the text is already a valid Vietnamese word
+func (e *IBusBambooEngine) getSpellingCandidates() []string {
+	var text = e.getProcessedString(bamboo.VietnameseMode)
+	if text == "" {
+		return nil
+	}
+	for _, c := range text {
+		if !unicode.IsLetter(c) {
+			return nil
+		}
+	}
+	var isValid = e.preeditor.IsValid(true) && checkSpellingRules(text, true)
+	if isValid && e.config.IBflags&config.IBspellCheckWithDicts != 0 {
+		isValid = isDictionaryWord(text)
+	}
+	if isValid {
+		return nil
+	}
+	if lexicon.IsEmpty() {
+		loadLexicon(e.engineName)
+	}
+	var words = lexicon.Correct(text, MaxCompletionWords)
+	for i, word := range words {
+		words[i] = applyCaseOf(text, word)
+	}
+	return words
+}
+
+// openSpellingCandidates lists the corrections of a misspelled pre-edit text,
+// wordBreak is the key to be committed after the picked word
+func (e *IBusBambooEngine) openSpellingCandidates(wordBreak string) bool {
+	e.spellingCheckedText = e.getPreeditString()
+	var candidates = e.getSpellingCandidates()
+	if len(candidates) == 0 {
+		return false
+	}
+	e.isMacroCandidates = false
+	e.isSpellingCandidates = true
+	e.spellingWordBreak = wordBreak
+	e.showCandidates(candidates, true)
+	return true
+}
+
+// shouldSuggestSpelling tells if a word break should list the corrections of the
+// pre-edit text first, once per text so that pressing it again commits the text as is
+func (e *IBusBambooEngine) shouldSuggestSpelling(keyRune rune, state uint32) bool {
+	if e.config.IBflags&config.IBspellingSuggestion == 0 {
+		return false
+	}
+	if !isValidState(state) || !bamboo.IsWordBreakSymbol(keyRune) || e.preeditor.CanProcessKey(keyRune) {
+		return false
+	}
+	if ok, _ := e.getMacroText(); ok {
+		return false
+	}
+	return e.getPreeditString() != e.spellingCheckedText
+}
+
+func (e *IBusBambooEngine) showCandidates(candidates []string, cursorVisible bool) {
+	lt := ibus.NewLookupTable()
+	lt.Orientation = IBusOrientationHorizontal
+	lt.PageSize = uint32(CandidateMaxPageSize)
+	lt.CursorVisible = cursorVisible
+	for _, candidate := range candidates {
+		if e.isMacroCandidates {
+			lt.AppendCandidate(e.getMacroPreview(candidate))
+		} else {
+			lt.AppendCandidate(candidate)
+		}
+	}
+	e.candidates = candidates
+	e.candidateLookupTable = lt
+	e.isCandidateLTOpened = true
+	e.isCandidateBrowsed = false
+	e.UpdateLookupTable(lt, true)
+}
+
+func (e *IBusBambooEngine) candidateProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) (bool, bool) {
+	var keyRune = rune(keyVal)
+	if keyVal == IBusUp {
+		e.CursorUp()
+		e.isCandidateBrowsed = true
+		return true, true
+	} else if keyVal == IBusDown {
+		e.CursorDown()
+		e.isCandidateBrowsed = true
+		return true, true
+	} else if keyVal == IBusPageUp {
+		e.PageUp()
+		e.isCandidateBrowsed = true
+		return true, true
+	} else if keyVal == IBusPageDown {
+		e.PageDown()
+		e.isCandidateBrowsed = true
+		return true, true
+	}
+	if keyVal == IBusReturn && e.candidateLookupTable.CursorVisible {
+		e.commitCandidate()
+		return true, true
+	}
+	if keyVal == IBusEscape {
+		e.closeCandidates()
+		return true, true
+	}
+	// the digits pick a candidate once the user browses the table, or the corrections
+	// of a word which has been ended, otherwise they are typed, e.g. x2 or the tones of VNI
+	var isSelecting = e.isCandidateBrowsed || e.isSpellingCandidates
+	if keyRune >= '1' && keyRune <= '9' && isValidState(state) && isSelecting {
+		if e.updateCursorPosInCandidateTable(uint32(keyRune - '1')) {
+			e.commitCandidate()
+			return true, true
+		}
+	}
+	if e.config.IBflags&config.IBtoneLessTyping != 0 && !e.isMacroCandidates && !e.isSpellingCandidates && isValidState(state) && bamboo.IsWordBreakSymbol(keyRune) {
+		var pos = e.candidateLookupTable.CursorPos
+		// the text is committed as typed if no word is more likely than the others
+		if !e.isCandidateBrowsed && len(e.candidates) > 1 && !lexicon.IsPreferred(e.previousWord, e.candidates[0], e.candidates[1]) {
+			return false, false
+		}
+		if pos < uint32(len(e.candidates)) {
+			e.learnCandidate(e.candidates[pos])
+			e.commitPreeditAndReset(e.candidates[pos] + string(keyRune))
+			return true, true
+		}
+	}
+	return false, false
+}
+
+func (e *IBusBambooEngine) updateCursorPosInCandidateTable(idx uint32) bool {
+	pageSize := e.candidateLookupTable.PageSize
+	if idx >= pageSize {
+		return false
+	}
+	page := e.candidateLookupTable.CursorPos / pageSize
+	newPos := page*pageSize + idx
+	if int(newPos) >= len(e.candidates) {
+		return false
+	}
+	e.candidateLookupTable.CursorPos = newPos
+	return true
+}
+
+func (e *IBusBambooEngine) updateCandidateLookupTable() {
+	e.candidateLookupTable.CursorVisible = true
+	e.UpdateLookupTable(e.candidateLookupTable, len(e.candidates) > 0)
+}
+
+func (e *IBusBambooEngine) commitCandidate() {
+	if pos := e.candidateLookupTable.CursorPos; pos < uint32(len(e.candidates)) {
+		var word = e.candidates[pos]
+		if e.isMacroCandidates {
+			if e.config.IBflags&config.IBautoCapitalizeMacro != 0 {
+				// the keys are looked up regardless of their case then
+				word = e.applyMacroCase(word)
+			}
+			e.commitPreeditAndReset(e.expandMacro(word))
+			return
+		}
+		e.learnCandidate(word)
+		if e.isSpellingCandidates {
+			e.commitPreeditAndReset(word + e.spellingWordBreak)
+			return
+		}
+		e.commitPreeditAndReset(word)
+	}
+}
+
+func (e *IBusBambooEngine) learnCandidate(word string) {
+	lexicon.Learn(word)
+	lexicon.LearnContext(e.previousWord, word)
+	e.previousWord = strings.ToLower(word)
+}
+
+func (e *IBusBambooEngine) closeCandidates() {
+	e.candidateLookupTable = nil
+	e.candidates = nil
+	e.isMacroCandidates = false
+	e.isSpellingCandidates = false
+	e.isCandidateBrowsed = false
+	e.spellingWordBreak = ""
+	e.UpdateLookupTable(ibus.NewLookupTable(), true) // workaround for issue #18
+	e.HideLookupTable()
+	e.isCandidateLTOpened = false
+}
+
+// learnWord counts the committed words, so that completion could rank them by frequency
+func (e *IBusBambooEngine) learnWord(text string) {
+	if !e.isLexiconEnabled() {
+		return
+	}
+	var word = strings.TrimRightFunc(text, func(c rune) bool {
+		return !unicode.IsLetter(c)
+	})
+	if lexicon.HasWord(word) {
+		lexicon.Learn(word)
+		e.previousWord = strings.ToLower(word)
+	} else {
+		e.previousWord = ""
+	}
+}
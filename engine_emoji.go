@@ -20,6 +20,7 @@
 package main
 
 import (
+	"ibus-bamboo/config"
 	"strconv"
 
 	"github.com/BambooEngine/bamboo-core"
@@ -29,6 +30,8 @@ import (
 const EmojiMaxPageSize = 9
 
 func (e *IBusBambooEngine) openEmojiList() {
+	// the keys are composed with the active input method to search the Vietnamese keywords
+	e.emoji.Composer = bamboo.NewEngine(e.preeditor.GetInputMethod(), e.config.Flags)
 	e.emoji.ProcessKey(':')
 	e.UpdatePreeditText(ibus.NewText(":"), 1, true)
 	e.UpdateAuxiliaryText(ibus.NewText(":"), true)
@@ -51,12 +54,25 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 		reset()
 		return false
 	}
+	// Shift+Enter shows the skin tone and gender variants of the selected emoji
+	if keyVal == IBusReturn && state&IBusShiftMask != 0 && !e.emoji.IsShowingVariants() {
+		var cps = e.emoji.Query()
+		if pos := e.emojiLookupTable.CursorPos; pos < uint32(len(cps)) && e.emoji.ShowVariants(cps[pos]) {
+			e.rebuildEmojiLookupTable()
+		}
+		return true
+	}
+	if e.emoji.IsShowingVariants() && (keyVal == IBusEscape || keyVal == IBusBackSpace) {
+		e.emoji.HideVariants()
+		e.rebuildEmojiLookupTable()
+		return true
+	}
 	if keyVal == IBusReturn {
 		if rawTextLen > 0 {
 			if len(e.emojiLookupTable.Candidates) > 0 {
 				e.commitEmojiCandidate()
 			} else {
-				e.CommitText(ibus.NewText(raw))
+				e.CommitText(ibus.NewText(e.emoji.GetQueryString()))
 			}
 			reset()
 			return true
@@ -65,7 +81,7 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 	}
 	if keyVal == IBusEscape {
 		if rawTextLen > 0 {
-			e.CommitText(ibus.NewText(raw))
+			e.CommitText(ibus.NewText(e.emoji.GetQueryString()))
 			reset()
 			return true
 		}
@@ -92,6 +108,7 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 			return false
 		}
 	} else if (keyRune >= 'a' && keyRune <= 'z') || (keyRune >= 'A' && keyRune <= 'Z') {
+		e.emoji.HideVariants()
 		var testStr = string(append(e.emoji.keys, keyRune))
 		if raw == ":" && !e.emoji.MatchString(testStr) {
 			e.emoji.Reset()
@@ -109,22 +126,24 @@ func (e *IBusBambooEngine) emojiProcessKeyEvent(keyVal uint32, keyCode uint32, s
 		}
 		return false
 	} else if (keyRune >= ' ' && keyRune <= '~') || bamboo.IsWordBreakSymbol(keyRune) {
+		e.emoji.HideVariants()
 		var testStr = string(append(e.emoji.keys, keyRune))
 		if raw == ":" && !e.emoji.MatchString(testStr) {
 			e.emoji.Reset()
 		}
 		e.emoji.ProcessKey(keyRune)
-		if !e.emoji.MatchString(string(e.emoji.keys)) {
-			e.CommitText(ibus.NewText(e.emoji.GetRawString()))
+		if !e.emoji.HasMatch() {
+			e.CommitText(ibus.NewText(e.emoji.GetQueryString()))
 			reset()
 			return true
 		}
 	} else if rawTextLen > 0 {
+		raw = e.emoji.GetQueryString()
 		reset()
 		e.CommitText(ibus.NewText(raw))
 		return false
 	}
-	raw = e.emoji.GetRawString()
+	raw = e.emoji.GetQueryString()
 	rawTextLen = len([]rune(raw))
 	cps := e.emoji.Query()
 	if cps != nil {
@@ -176,11 +195,43 @@ func (e *IBusBambooEngine) commitEmojiCandidate() {
 	var cps = e.emoji.Query()
 	if pos := e.emojiLookupTable.CursorPos; pos < uint32(len(cps)) {
 		e.CommitText(ibus.NewText(cps[pos]))
+		e.emoji.Use(cps[pos])
+		// the skin tone picked from the variants becomes the default one,
+		// picking a variant without a skin tone, e.g. a gender one, keeps it
+		if skinTone, _ := getSkinTone(cps[pos]); e.emoji.IsShowingVariants() && skinTone > 0 {
+			e.setEmojiSkinTone(skinTone)
+		}
 	}
 }
 
+func (e *IBusBambooEngine) setEmojiSkinTone(skinTone int) {
+	if e.emoji.SkinTone == skinTone {
+		return
+	}
+	e.emoji.SkinTone = skinTone
+	e.config.EmojiSkinTone = skinTone
+	e.globalConfig.EmojiSkinTone = skinTone
+	config.SaveConfig(e.globalConfig, e.engineName)
+}
+
+func (e *IBusBambooEngine) rebuildEmojiLookupTable() {
+	lt := ibus.NewLookupTable()
+	lt.Orientation = IBusOrientationHorizontal
+	for _, codePoint := range e.emoji.Query() {
+		lt.AppendCandidate(codePoint)
+	}
+	lt.PageSize = uint32(EmojiMaxPageSize)
+	e.emojiLookupTable = lt
+	if e.emoji.IsShowingVariants() {
+		e.UpdateAuxiliaryText(ibus.NewText("Chọn biến thể (màu da, giới tính)"), true)
+	} else {
+		e.UpdateAuxiliaryText(ibus.NewText(e.emoji.GetQueryString()), true)
+	}
+	e.updateEmojiLookupTable()
+}
+
 func (e *IBusBambooEngine) refreshEmojiCandidate() {
-	var raw = e.emoji.GetRawString()
+	var raw = e.emoji.GetQueryString()
 	var rawTextLen = len([]rune(raw))
 	e.UpdatePreeditTextWithMode(ibus.NewText(raw), uint32(rawTextLen), true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
 	e.UpdateAuxiliaryText(ibus.NewText(raw), true)
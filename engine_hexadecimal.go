@@ -23,92 +23,124 @@ package main
 import (
 	"fmt"
 	"log"
-	"strconv"
+	"strings"
+	"unicode"
 
-	"github.com/BambooEngine/bamboo-core"
 	ibus "github.com/BambooEngine/goibus"
 )
 
 func (e *IBusBambooEngine) hexadecimalProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) bool {
-	var rawKeyLen = e.getRawKeyLen()
 	if keyVal >= 0xffb0 && keyVal <= 0xffb9 {
 		keyVal = keyVal - 0xffb0 + 0x0030
 	}
 	var keyRune = rune(keyVal)
-	var mode = bamboo.EnglishMode | bamboo.FullText
-	var oldText = e.getProcessedString(mode)
 	defer e.updateLastKeyWithShift(keyVal, state)
 
-	if rawKeyLen == 0 || oldText[0] != 'u' {
-		e.closeHexadecimalInput()
-		return false
-	}
 	if keyVal == IBusEscape {
 		e.closeHexadecimalInput()
 		return true
 	}
 	if keyVal == IBusBackSpace {
-		if rawKeyLen > 2 {
-			e.preeditor.RemoveLastChar(true)
-			e.updateHexadecimal(e.getProcessedString(mode))
+		if len(e.hexBuffer) > 0 {
+			var chars = []rune(e.hexBuffer)
+			e.hexBuffer = string(chars[:len(chars)-1])
+			e.updateHexadecimal()
 		} else {
 			e.closeHexadecimalInput()
 		}
 		return true
 	}
-	if keyVal == IBusSpace || keyVal == IBusReturn || keyVal == 0xff8d {
-		if rawKeyLen > 1 {
-			value, err := strconv.ParseInt(oldText[1:], 16, 64)
-			if err != nil || value > 0x10ffff {
-				log.Println("Input is out of range")
-			} else {
-				log.Printf("Commit Text [%s]\n", fmt.Sprint(value))
-				e.CommitText(ibus.NewText(fmt.Sprint(value)))
-			}
-		}
-		e.closeHexadecimalInput()
+	if keyVal == IBusReturn || keyVal == 0xff8d {
+		e.commitHexadecimal()
 		return true
 	}
-
-	if (keyRune >= '0' && keyRune <= '9') || (keyRune >= 'A' && keyRune <= 'F') || (keyRune >= 'a' && keyRune <= 'f') {
-		if !isValidState(state) || !e.isValidKeyVal(keyVal) {
-			return true
+	if !isValidState(state) {
+		return true
+	}
+	var tokens = strings.Split(e.hexBuffer, " ")
+	var lastToken = tokens[len(tokens)-1]
+	// code points of a sequence are separated by spaces
+	if keyVal == IBusSpace {
+		if lastToken != "" {
+			e.hexBuffer += " "
+			e.updateHexadecimal()
 		}
-		e.preeditor.ProcessKey(keyRune, mode)
-		e.updateHexadecimal(e.getProcessedString(mode))
+		return true
+	}
+	if isCodePointKey(lastToken, keyRune) {
+		e.hexBuffer += string(keyRune)
+		e.updateHexadecimal()
 	}
 	return true
 }
 
 func (e *IBusBambooEngine) setupHexadecimalProcessKeyEvent() {
-	var keyVal = uint32(117)
-	var state = uint32(0)
-	var keyRune = rune(keyVal)
-	var mode = bamboo.EnglishMode | bamboo.FullText
-	defer e.updateLastKeyWithShift(keyVal, state)
-
-	if e.isValidKeyVal(keyVal) {
-		e.preeditor.ProcessKey(keyRune, mode)
+	if len(unicodeNames) == 0 {
+		var err error
+		if unicodeNames, err = loadUnicodeNames(DictUnicodeNames); err != nil {
+			log.Println(err)
+		}
 	}
-	e.updateHexadecimal(e.getProcessedString(mode))
+	e.hexBuffer = ""
+	e.updateHexadecimal()
 }
 
 func (e *IBusBambooEngine) closeHexadecimalInput() {
 	e.HidePreeditText()
-	e.preeditor.Reset()
+	e.HideAuxiliaryText()
+	e.hexBuffer = ""
 	e.isInHexadecimal = false
 }
 
-func (e *IBusBambooEngine) updateHexadecimal(processedStr string) {
-	var encodedStr = e.encodeText(processedStr)
-	var preeditLen = uint32(len([]rune(encodedStr)))
-	if preeditLen == 0 {
-		e.HidePreeditText()
-		e.CommitText(ibus.NewText(""))
-		return
+// getHexadecimalText returns the text of the typed code points, the tokens which are
+// not valid code points are returned as they are typed if keepInvalid is set
+func (e *IBusBambooEngine) getHexadecimalText(keepInvalid bool) string {
+	var sb strings.Builder
+	for _, token := range strings.Fields(e.hexBuffer) {
+		var text, ok = decodeCodePoint(token)
+		// control characters can't be previewed
+		if keepInvalid && strings.IndexFunc(text, func(c rune) bool { return !unicode.IsGraphic(c) }) >= 0 {
+			ok = false
+		}
+		if ok {
+			sb.WriteString(text)
+		} else if keepInvalid {
+			sb.WriteString(token)
+		} else {
+			log.Printf("Invalid code point [%s]\n", token)
+		}
 	}
-	var ibusText = ibus.NewText(encodedStr)
+	return sb.String()
+}
 
+func (e *IBusBambooEngine) commitHexadecimal() {
+	var text = e.getHexadecimalText(false)
+	if text != "" {
+		log.Printf("Commit Text [%s]\n", text)
+		e.CommitText(ibus.NewText(text))
+	}
+	e.closeHexadecimalInput()
+}
+
+func (e *IBusBambooEngine) updateHexadecimal() {
+	var preview = e.getHexadecimalText(true)
+	if preview == "" {
+		preview = "u"
+	}
+	var preeditLen = uint32(len([]rune(preview)))
+	var ibusText = ibus.NewText(preview)
 	ibusText.AppendAttr(ibus.IBUS_ATTR_TYPE_UNDERLINE, ibus.IBUS_ATTR_UNDERLINE_SINGLE, 0, preeditLen)
 	e.UpdatePreeditTextWithMode(ibusText, preeditLen, true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
+
+	var aux = "Unicode: 1ea1, #7841, &agrave;"
+	var tokens = strings.Fields(e.hexBuffer)
+	if len(tokens) > 0 {
+		aux = "u" + e.hexBuffer
+		if text, ok := decodeCodePoint(tokens[len(tokens)-1]); ok {
+			for _, c := range text {
+				aux += fmt.Sprintf(" | U+%04X %s", c, getUnicodeName(unicodeNames, c))
+			}
+		}
+	}
+	e.UpdateAuxiliaryText(ibus.NewText(aux), true)
 }
@@ -23,6 +23,7 @@ import (
 	"ibus-bamboo/config"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/BambooEngine/bamboo-core"
@@ -36,14 +37,25 @@ func (e *IBusBambooEngine) preeditProcessKeyEvent(keyVal uint32, keyCode uint32,
 	var oldText = e.getPreeditString()
 	defer e.updateLastKeyWithShift(keyVal, state)
 
-	if !e.shouldRestoreKeyStrokes {
-		if !e.preeditor.CanProcessKey(keyRune) && rawKeyLen == 0 && e.config.IBflags&config.IBmacroEnabled == 0 {
-			// don't process special characters if rawKeyLen == 0,
-			// workaround for Chrome's address bar and Google SpreadSheets
-			return false, nil
+	if e.isCandidateLTOpened {
+		if handled, ret := e.candidateProcessKeyEvent(keyVal, keyCode, state); handled {
+			return ret, nil
 		}
 	}
 
+	if rawKeyLen == 0 {
+		e.checkBackspaceAfterConversion(keyVal)
+	}
+	if rawKeyLen == 0 && e.reconvertProcessKeyEvent(keyVal, keyCode, state) {
+		return true, nil
+	}
+
+	if !e.preeditor.CanProcessKey(keyRune) && rawKeyLen == 0 && e.config.IBflags&config.IBmacroEnabled == 0 {
+		// don't process special characters if rawKeyLen == 0,
+		// workaround for Chrome's address bar and Google SpreadSheets
+		return false, nil
+	}
+
 	if keyVal == IBusBackSpace {
 		if e.runeCount() == 1 {
 			e.commitPreeditAndReset("")
@@ -67,9 +79,15 @@ func (e *IBusBambooEngine) preeditProcessKeyEvent(keyVal uint32, keyCode uint32,
 		return true, nil
 	}
 
+	if rawKeyLen > 0 && e.shouldSuggestSpelling(keyRune, state) && e.openSpellingCandidates(string(keyRune)) {
+		return true, nil
+	}
+
+	var typedKeys = e.getTypedKeys()
 	newText, isWordBreakRune := e.getCommitText(keyVal, keyCode, state)
 	isPrintableKey := e.isPrintableKey(state, keyVal)
 	if isWordBreakRune {
+		e.checkRetypedWord(newText, typedKeys)
 		e.commitPreeditAndResetForWBS(newText, isPrintableKey)
 		return isPrintableKey, nil
 	}
@@ -79,29 +97,38 @@ func (e *IBusBambooEngine) preeditProcessKeyEvent(keyVal uint32, keyCode uint32,
 
 func (e *IBusBambooEngine) expandMacro(str string) string {
 	var macroText = e.macroTable.GetText(str)
+	if strings.HasPrefix(macroText, macroCommandPrefix) {
+		// the command runs once the expansion is committed
+		var command = strings.TrimSpace(strings.TrimPrefix(macroText, macroCommandPrefix))
+		return string(macroCommandStart) + command + string(macroCommandEnd)
+	}
+	var adjustCase = func(s string) string { return s }
 	if e.config.IBflags&config.IBautoCapitalizeMacro != 0 {
 		switch determineMacroCase(str) {
 		case VnCaseAllSmall:
-			return strings.ToLower(macroText)
+			adjustCase = strings.ToLower
 		case VnCaseAllCapital:
-			return strings.ToUpper(macroText)
+			adjustCase = strings.ToUpper
 		}
 	}
-	return macroText
+	return expandMacroPlaceholders(macroText, adjustCase, e.getWmClass())
 }
 
 func (e *IBusBambooEngine) updatePreedit(processedStr string) {
+	processedStr, _ = removeMacroCursor(removeMacroCommands(processedStr))
 	var encodedStr = e.encodeText(processedStr)
 	var preeditLen = uint32(len([]rune(encodedStr)))
 	if preeditLen == 0 {
 		e.HidePreeditText()
 		e.HideAuxiliaryText()
 		e.CommitText(ibus.NewText(""))
+		e.updateCandidates(processedStr)
 		return
 	}
 	var ibusText = ibus.NewText(encodedStr)
 	if inStringList(enabledAuxiliaryTextList, e.getWmClass()) && e.config.IBflags&config.IBworkaroundForWPS != 0 {
 		e.UpdateAuxiliaryText(ibusText, true)
+		e.updateCandidates(processedStr)
 		return
 	}
 
@@ -109,9 +136,14 @@ func (e *IBusBambooEngine) updatePreedit(processedStr string) {
 		ibusText.AppendAttr(ibus.IBUS_ATTR_TYPE_UNDERLINE, ibus.IBUS_ATTR_UNDERLINE_SINGLE, 0, preeditLen)
 	}
 	e.UpdatePreeditTextWithMode(ibusText, preeditLen, true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
+	e.updateCandidates(processedStr)
 }
 
 func (e *IBusBambooEngine) getBambooInputMode() bamboo.Mode {
+	// words typed without diacritics are restored from the lookup table
+	if e.config.IBflags&config.IBtoneLessTyping != 0 && e.checkInputMode(config.PreeditIM) {
+		return bamboo.EnglishMode
+	}
 	if e.shouldFallbackToEnglish(false) {
 		return bamboo.EnglishMode
 	}
@@ -138,10 +170,13 @@ func (e *IBusBambooEngine) shouldFallbackToEnglish(checkVnRune bool) bool {
 	if checkVnRune && !bamboo.HasAnyVietnameseRune(vnSeq) {
 		return false
 	}
-	return !e.preeditor.IsValid(false)
+	return !e.preeditor.IsValid(false) || !checkSpellingRules(vnSeq, false)
 }
 
 func (e *IBusBambooEngine) mustFallbackToEnglish() bool {
+	if exceptions.Has(e.getTypedKeys()) {
+		return true
+	}
 	if e.config.IBflags&config.IBautoNonVnRestore == 0 {
 		return false
 	}
@@ -154,10 +189,101 @@ func (e *IBusBambooEngine) mustFallbackToEnglish() bool {
 	if e.config.IBflags&config.IBddFreeStyle != 0 && strings.ContainsRune(vnSeq, 'đ') {
 		return false
 	}
+	if e.config.IBflags&config.IBenglishProtection != 0 && e.isEnglishWord(vnSeq) {
+		return true
+	}
 	if e.config.IBflags&config.IBspellCheckWithDicts != 0 {
-		return !dictionary[vnSeq]
+		return !isDictionaryWord(vnSeq)
+	}
+	return !e.preeditor.IsValid(true) || !checkSpellingRules(vnSeq, true)
+}
+
+// isEnglishWord tells if the keys typed for vnSeq spell a common English word,
+// unless the user has already committed vnSeq as a Vietnamese word
+func (e *IBusBambooEngine) isEnglishWord(vnSeq string) bool {
+	var keys = e.getProcessedString(bamboo.EnglishMode | bamboo.LowerCase)
+	return englishWords[keys] && lexicon.GetFrequency(vnSeq) == 0
+}
+
+// getTypedKeys returns the keys typed for the last word of the pre-edit text
+func (e *IBusBambooEngine) getTypedKeys() string {
+	var words = strings.Fields(e.getProcessedString(bamboo.EnglishMode | bamboo.LowerCase))
+	if len(words) == 0 {
+		return ""
+	}
+	return strings.TrimRightFunc(words[len(words)-1], bamboo.IsPunctuationMark)
+}
+
+func (e *IBusBambooEngine) learnException(keys string) {
+	if err := exceptions.Learn(keys); err != nil {
+		log.Println(err)
 	}
-	return !e.preeditor.IsValid(true)
+}
+
+// forgetException stops leaving the keys as typed once the user has switched them back to Vietnamese
+func (e *IBusBambooEngine) forgetException(keys string) {
+	if _, err := exceptions.Remove(keys); err != nil {
+		log.Println(err)
+	}
+}
+
+// addCurrentWordToDictionary adds the word being typed, or the word committed last in
+// the backspace modes, to the user dictionary so that the spell checking keeps it
+func (e *IBusBambooEngine) addCurrentWordToDictionary() bool {
+	if e.getRawKeyLen() == 0 {
+		var word = e.lastCommittedWord
+		if word == nil {
+			return false
+		}
+		if err := addToUserDictionary(config.GetUserDictPath(e.engineName), word.vietnamese); err != nil {
+			log.Println(err)
+		}
+		e.forgetException(word.keys)
+		if word.text != word.vietnamese {
+			e.restoreCommittedWord()
+		}
+		return true
+	}
+	var oldText = e.getPreeditString()
+	if err := addToUserDictionary(config.GetUserDictPath(e.engineName), e.getProcessedString(bamboo.VietnameseMode|bamboo.LowerCase)); err != nil {
+		log.Println(err)
+	}
+	e.forgetException(e.getTypedKeys())
+	var newText = e.getPreeditString()
+	if e.inBackspaceWhiteList() {
+		e.updatePreviousText(oldText, newText)
+	} else {
+		e.updatePreedit(newText)
+	}
+	return true
+}
+
+// checkRetypedWord learns the keys of a converted word as an exception once the user
+// has deleted the word right after it was committed and typed the keys as they were
+func (e *IBusBambooEngine) checkRetypedWord(committed, keys string) {
+	var words = strings.Fields(committed)
+	if len(words) == 0 || keys == "" {
+		e.convertedKeys, e.retypedKeys = "", ""
+		return
+	}
+	var word = strings.ToLower(strings.TrimRightFunc(words[len(words)-1], bamboo.IsPunctuationMark))
+	if e.retypedKeys != "" && word == e.retypedKeys {
+		e.learnException(word)
+	}
+	e.retypedKeys = ""
+	e.convertedKeys = ""
+	if word != keys && bamboo.HasAnyVietnameseRune(word) {
+		e.convertedKeys = keys
+	}
+}
+
+// checkBackspaceAfterConversion remembers the keys of the converted word
+// if the first key pressed after committing it is a Backspace
+func (e *IBusBambooEngine) checkBackspaceAfterConversion(keyVal uint32) {
+	if keyVal == IBusBackSpace && e.convertedKeys != "" {
+		e.retypedKeys = e.convertedKeys
+	}
+	e.convertedKeys = ""
 }
 
 func (e *IBusBambooEngine) getComposedString(oldText string) string {
@@ -192,6 +318,7 @@ func (e *IBusBambooEngine) resetPreedit() {
 }
 
 func (e *IBusBambooEngine) commitPreeditAndResetForWBS(s string, isWBS bool) {
+	e.spellingCheckedText = ""
 	if e.config.IBflags&config.IBworkaroundForFBMessenger != 0 || isWBS {
 		// Fix missing the first word while typing in FB Messager as FB prefers
 		// committing text before hiding preedit
@@ -203,13 +330,17 @@ func (e *IBusBambooEngine) commitPreeditAndResetForWBS(s string, isWBS bool) {
 	}
 	e.HideAuxiliaryText()
 	e.HideLookupTable()
+	e.isCandidateLTOpened = false
+	e.learnWord(s)
 	e.preeditor.Reset()
 }
 
 func (e *IBusBambooEngine) commitPreeditAndReset(s string) {
+	e.spellingCheckedText = ""
 	e.HidePreeditText()
 	e.HideAuxiliaryText()
 	e.HideLookupTable()
+	e.isCandidateLTOpened = false
 	e.commitText(s)
 	e.preeditor.Reset()
 }
@@ -218,10 +349,63 @@ func (e *IBusBambooEngine) commitText(str string) {
 	if str == "" {
 		return
 	}
+	if parts := splitMacroCommands(str); len(parts) > 1 {
+		e.runMacroCommands(parts, e.commitText)
+		return
+	}
+	str, nLeft := removeMacroCursor(insertMacroClipboard(str))
 	log.Printf("Commit Text [%s]\n", str)
 	var now = time.Now()
 	e.lastCommitText = now.UnixNano()
+	// the client sends the new surrounding text after the commit
+	e.textBeforeCursor = nil
 	e.CommitText(ibus.NewText(e.encodeText(str)))
+	e.moveCursorLeft(nLeft)
+}
+
+// macroCommandRunner runs the commands of `!cmd:` macros, tests replace it
+var macroCommandRunner = runMacroCommand
+
+// runMacroCommands commits the text of a macro up to its first `!cmd:` command, the commands at
+// the odd indexes of parts run in the background, within MacroCommandTimeout each, so that the
+// keys are still processed meanwhile. Their outputs and the rest of the text are committed once
+// they finish, unless the focus has moved to another client
+func (e *IBusBambooEngine) runMacroCommands(parts []string, commit func(string)) {
+	commit(parts[0])
+	var focusSerial = atomic.LoadUint32(&e.focusSerial)
+	var allowed = e.config.MacroCommands
+	var timeout = time.Duration(e.config.MacroCommandTimeout) * time.Millisecond
+	e.macroCommands.Add(1)
+	go func() {
+		defer e.macroCommands.Done()
+		var sb strings.Builder
+		for i := 1; i < len(parts); i++ {
+			if i%2 == 0 {
+				sb.WriteString(parts[i])
+				continue
+			}
+			out, err := macroCommandRunner(parts[i], allowed, timeout)
+			if err != nil {
+				log.Println("Macro command failed:", err)
+			}
+			sb.WriteString(out)
+		}
+		e.Lock()
+		defer e.Unlock()
+		if atomic.LoadUint32(&e.focusSerial) != focusSerial {
+			log.Println("Dropped the output of the macro commands, the focus has moved")
+			return
+		}
+		commit(sb.String())
+	}()
+}
+
+// moveCursorLeft puts the caret where the {cursor} placeholder of a macro was
+func (e *IBusBambooEngine) moveCursorLeft(n int) {
+	for i := 0; i < n; i++ {
+		e.ForwardKeyEvent(IBusLeft, XkLeft-8, 0)
+		e.ForwardKeyEvent(IBusLeft, XkLeft-8, IBusReleaseMask)
+	}
 }
 
 func (e *IBusBambooEngine) getVnSeq() string {
@@ -0,0 +1,101 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"ibus-bamboo/config"
+	"reflect"
+	"unicode"
+
+	"github.com/BambooEngine/bamboo-core"
+	"github.com/godbus/dbus/v5"
+)
+
+const MaxReconversionWordLen = 10
+
+func (e *IBusBambooEngine) updateTextBeforeCursor(text dbus.Variant, cursorPos uint32, anchorPos uint32) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println(err)
+		}
+	}()
+	e.textBeforeCursor = nil
+	if cursorPos != anchorPos {
+		// there is a selection
+		return
+	}
+	var s = []rune(reflect.ValueOf(reflect.ValueOf(text.Value()).Index(2).Interface()).String())
+	if len(s) < int(cursorPos) {
+		return
+	}
+	e.textBeforeCursor = s[:cursorPos]
+}
+
+// getWordBeforeCursor returns the letters right before the caret
+func (e *IBusBambooEngine) getWordBeforeCursor() []rune {
+	var i = len(e.textBeforeCursor)
+	for i > 0 && unicode.IsLetter(e.textBeforeCursor[i-1]) {
+		i--
+	}
+	var word = e.textBeforeCursor[i:]
+	if len(word) > MaxReconversionWordLen {
+		return nil
+	}
+	return word
+}
+
+// reconvertProcessKeyEvent pulls the committed word before the caret back into the pre-edit,
+// when Backspace or a key that changes the word (e.g. a tone key) is pressed right after it
+func (e *IBusBambooEngine) reconvertProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) bool {
+	if e.config.IBflags&config.IBreconversion == 0 || e.capabilities&IBusCapSurroundingText == 0 {
+		return false
+	}
+	// the surrounding text is in unicode
+	if e.config.OutputCharset != "Unicode" || !isValidState(state) {
+		return false
+	}
+	var keyRune = rune(keyVal)
+	if keyVal != IBusBackSpace && !e.preeditor.CanProcessKey(keyRune) {
+		return false
+	}
+	var word = e.getWordBeforeCursor()
+	if len(word) == 0 || (keyVal == IBusBackSpace && len(word) == 1) {
+		return false
+	}
+	e.preeditor.Reset()
+	for i := len(word) - 1; i >= 0; i-- {
+		e.preeditor.ProcessKey(word[i], bamboo.EnglishMode|bamboo.InReverseOrder)
+	}
+	if keyVal == IBusBackSpace {
+		e.preeditor.RemoveLastChar(true)
+	} else {
+		e.preeditor.ProcessKey(keyRune, e.getBambooInputMode())
+		if e.getPreeditString() == string(word)+string(keyRune) {
+			// the key doesn't change the word, let it be typed as usual
+			e.preeditor.Reset()
+			return false
+		}
+	}
+	e.textBeforeCursor = nil
+	e.DeleteSurroundingText(-int32(len(word)), uint32(len(word)))
+	e.updatePreedit(e.getPreeditString())
+	return true
+}
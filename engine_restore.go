@@ -0,0 +1,109 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+// committedWord is the word committed on the last word break in the backspace modes,
+// kept so that its key strokes could still be restored
+type committedWord struct {
+	text       string
+	keys       string
+	vietnamese string
+	suffix     string
+}
+
+// restoreKeyStrokes switches the current word between the keys typed for it and its
+// Vietnamese form, the word committed last is switched in the backspace modes
+// if no word is being typed
+func (e *IBusBambooEngine) restoreKeyStrokes() bool {
+	if e.getRawKeyLen() == 0 {
+		if e.inBackspaceWhiteList() {
+			return e.restoreCommittedWord()
+		}
+		return false
+	}
+	var oldText = e.getPreeditString()
+	var toVietnamese = !bamboo.HasAnyVietnameseRune(oldText)
+	if toVietnamese {
+		e.forgetException(e.getTypedKeys())
+	} else {
+		e.learnException(e.getTypedKeys())
+	}
+	e.preeditor.RestoreLastWord(toVietnamese)
+	var newText = e.getPreeditString()
+	if toVietnamese {
+		e.learnVietnameseWord(newText)
+	}
+	if e.inBackspaceWhiteList() {
+		e.updatePreviousText(oldText, newText)
+	} else {
+		e.updatePreedit(newText)
+	}
+	return true
+}
+
+func (e *IBusBambooEngine) restoreCommittedWord() bool {
+	var word = e.lastCommittedWord
+	if word == nil || word.keys == word.vietnamese {
+		return false
+	}
+	var newText = word.vietnamese
+	if word.text == word.vietnamese {
+		newText = word.keys
+		e.learnException(word.keys)
+	} else {
+		e.forgetException(word.keys)
+		e.learnVietnameseWord(word.vietnamese)
+	}
+	e.SendBackSpace(utf8.RuneCountInString(word.text + word.suffix))
+	e.bsCommitText([]rune(newText + word.suffix))
+	word.text = newText
+	return true
+}
+
+// learnVietnameseWord counts a word the user has switched back to Vietnamese,
+// so that the English protection no longer keeps its keys as typed
+func (e *IBusBambooEngine) learnVietnameseWord(word string) {
+	if e.isLexiconEnabled() {
+		lexicon.Learn(word)
+	}
+}
+
+// rememberCommittedWord keeps the word committed by a word break in the backspace modes,
+// keys and vietnamese are the forms of the word before the break was typed, the words
+// committed as something else, e.g. a macro, are not kept
+func (e *IBusBambooEngine) rememberCommittedWord(newText, keys, vietnamese string, keyVal, state uint32) {
+	var suffix string
+	if e.isPrintableKey(state, keyVal) {
+		suffix = string(rune(keyVal))
+	}
+	var text = strings.TrimSuffix(newText, suffix)
+	if keys == "" || (text != keys && text != vietnamese) {
+		e.lastCommittedWord = nil
+		return
+	}
+	e.lastCommittedWord = &committedWord{text: text, keys: keys, vietnamese: vietnamese, suffix: suffix}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"ibus-bamboo/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/BambooEngine/bamboo-core"
+	"github.com/godbus/dbus/v5"
 )
 
 type keyEvent struct {
@@ -83,6 +90,22 @@ func TestPreeditEngine(t *testing.T) {
 			name:      "duowidro_enter",
 			keyEvents: generateKeyEvents("duowidro", []string{"d", "du", "duo", "dươ", "dươi", "đươi", "đưởi", "đuổi"}, enter("đuổi")),
 		},
+		{
+			name:      "text_stop_final_tone",
+			keyEvents: generateKeyEvents("text", []string{"t", "te", "tẽ", "text"}, enter("text")),
+		},
+		{
+			name:      "tapf_stop_final_tone",
+			keyEvents: generateKeyEvents("tapf", []string{"t", "ta", "tap", "tapf"}, enter("tapf")),
+		},
+		{
+			name:      "hocj_stop_final_tone",
+			keyEvents: generateKeyEvents("hocj", []string{"h", "ho", "hoc", "học"}, enter("học")),
+		},
+		{
+			name:      "kas_k_before_a",
+			keyEvents: generateKeyEvents("kas", []string{"k", "ka", "kas"}, enter("kas")),
+		},
 		{
 			name:      "macro_vowl_space",
 			mTable:    map[string]string{"vn": "việt nam"},
@@ -302,3 +325,409 @@ func assertEngine(t testing.TB, tc testCase, assertFn func(testing.TB, *fakeEngi
 	}
 	assertFn(t, fe, e)
 }
+
+// saveWordLists returns a function putting back the word lists a test replaces,
+// e.g. defer saveWordLists()(), so that the tests don't depend on their order
+func saveWordLists() func() {
+	var savedLexicon, savedExceptions, savedEnglishWords = lexicon, exceptions, englishWords
+	var savedDictionary, savedDictionaryLoaded = dictionary, dictionaryLoaded
+	return func() {
+		lexicon, exceptions, englishWords = savedLexicon, savedExceptions, savedEnglishWords
+		dictionary, dictionaryLoaded = savedDictionary, savedDictionaryLoaded
+	}
+}
+
+// newTestEngine builds an engine typing into a fake client
+func newTestEngine(cfg *config.Config) (*IBusBambooEngine, *fakeEngine, func(string) string) {
+	fe := NewFakeEngine()
+	inputMethod := bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	e := NewIbusBambooEngine("test", cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	var typeText = func(s string) string {
+		fe.commitText = ""
+		for _, key := range s {
+			e.ProcessKeyEvent(uint32(key), uint32(key), 0)
+		}
+		return fe.commitText
+	}
+	return e, fe, typeText
+}
+
+func TestPreeditReconversion(t *testing.T) {
+	fe := NewFakeEngine()
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBreconversion
+	inputMethod := bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	e := NewIbusBambooEngine("test", &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	e.SetCapabilities(IBusCapPreeditText | IBusCapSurroundingText)
+	fe.commitText = "xin chao viet"
+	e.SetSurroundingText(makeSurroundingText(fe.commitText), 13, 13)
+	if ret, _ := e.ProcessKeyEvent('s', 's', 0); !ret {
+		t.Fatalf("Typing a tone key after a word, expected the key to be processed")
+	}
+	if fe.commitText != "xin chao " || fe.preeditText != "viét" {
+		t.Errorf("Reconversion, expected (xin chao |viét), got (%s|%s)", fe.commitText, fe.preeditText)
+	}
+	e.ProcessKeyEvent('e', 'e', 0)
+	if fe.preeditText != "viết" {
+		t.Errorf("Reconversion, expected preedit viết, got %s", fe.preeditText)
+	}
+
+	e.Reset()
+	fe.commitText = "xin chao"
+	e.SetSurroundingText(makeSurroundingText(fe.commitText), 8, 8)
+	e.ProcessKeyEvent('n', 'n', 0)
+	if fe.commitText != "xin chao" || fe.preeditText != "n" {
+		t.Errorf("Typing a plain letter after a word, expected (xin chao|n), got (%s|%s)", fe.commitText, fe.preeditText)
+	}
+}
+
+// makeSurroundingText builds an IBusText the way it arrives through D-Bus
+func makeSurroundingText(text string) dbus.Variant {
+	return dbus.MakeVariant([]interface{}{"IBusText", map[string]dbus.Variant{}, text, dbus.MakeVariant("")})
+}
+
+func TestApplyProfile(t *testing.T) {
+	fe := NewFakeEngine()
+	var cfg = config.DefaultCfg()
+	cfg.Profiles["mail"] = config.Profile{IBflagsOn: config.IBmacroEnabled, IBflagsOff: config.IBspellCheckEnabled}
+	cfg.Profiles["accounting"] = config.Profile{InputMethod: "VNI", OutputCharset: "TCVN3 (ABC)"}
+	inputMethod := bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	e := NewIbusBambooEngine("test", &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	e.checkWmClass("Thunderbird:mail")
+	if e.config.IBflags&config.IBmacroEnabled == 0 || e.config.IBflags&config.IBspellCheckEnabled != 0 {
+		t.Errorf("Profile of mail, expected macro on and spell check off, got IBflags %d", e.config.IBflags)
+	}
+	e.checkWmClass("accounting")
+	if e.config.InputMethod != "VNI" || e.config.OutputCharset != "TCVN3 (ABC)" || e.preeditor.GetInputMethod().Name != "VNI" {
+		t.Errorf("Profile of accounting, expected VNI and TCVN3, got %s and %s", e.config.InputMethod, e.config.OutputCharset)
+	}
+	e.checkWmClass("xterm")
+	if e.config != &cfg || e.preeditor.GetInputMethod().Name != "Telex" {
+		t.Errorf("Leaving an application with a profile, expected the global config")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	fe := NewFakeEngine()
+	var cfg = config.DefaultCfg()
+	inputMethod := bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	e := NewIbusBambooEngine("test", &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	e.SetContentType(IBusInputPurposePassword, 0)
+	if ret, _ := e.ProcessKeyEvent('a', 'a', 0); ret {
+		t.Errorf("Typing in a password field, expected the key to be passed through")
+	}
+	e.SetContentType(IBusInputPurposeTerminal, 0)
+	if e.config.IBflags&config.IBautoNonVnRestore != 0 || cfg.IBflags&config.IBautoNonVnRestore == 0 {
+		t.Errorf("Typing in a terminal, expected auto restore to be disabled for the terminal only")
+	}
+	e.SetContentType(IBusInputPurposeFreeForm, IBusInputHintUppercaseSentences)
+	if e.config != &cfg {
+		t.Errorf("Leaving the terminal, expected the global config")
+	}
+	for _, c := range "xin. c" {
+		e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+	}
+	if fe.preeditText != "C" {
+		t.Errorf("Starting a sentence, expected preedit C, got %s", fe.preeditText)
+	}
+	e.isSentenceStart = true
+	if keyVal := e.capitalizeSentence(IBusLeft, 0); keyVal != IBusLeft || !e.isSentenceStart {
+		t.Errorf("Pressing Left at the start of a sentence, expected Left to be left as is, got %#x", keyVal)
+	}
+	cfg.DefaultInputMode = config.SurroundingTextIM
+	e = NewIbusBambooEngine("test", &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	e.SetContentType(IBusInputPurposeFreeForm, IBusInputHintUppercaseSentences)
+	fe.commitText = ""
+	for _, c := range "xin. chaof" {
+		e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+	}
+	// the space is passed through to the client
+	if fe.commitText != "Xin.Chào" {
+		t.Errorf("Starting sentences in surrounding text mode, expected `Xin.Chào`, got `%s`", fe.commitText)
+	}
+}
+
+func TestHexadecimalInput(t *testing.T) {
+	defer func(names map[rune]string) { unicodeNames = names }(unicodeNames)
+	unicodeNames = map[rune]string{0x1EA1: "LATIN SMALL LETTER A WITH DOT BELOW"}
+	fe := NewFakeEngine()
+	var cfg = config.DefaultCfg()
+	inputMethod := bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	e := NewIbusBambooEngine("test", &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	e.ProcessKeyEvent('U', 'U', IBusControlMask|IBusShiftMask)
+	for _, c := range "1ea1 #233 &amp; &#x1F600;" {
+		e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+	}
+	if fe.preeditText != "ạé&😀" {
+		t.Errorf("Typing code points, expected preedit ạé&😀, got %s", fe.preeditText)
+	}
+	e.ProcessKeyEvent(IBusReturn, IBusReturn, 0)
+	if fe.commitText != "ạé&😀" || e.isInHexadecimal {
+		t.Errorf("Committing code points, expected ạé&😀, got %s", fe.commitText)
+	}
+	if s, ok := decodeCodePoint("&ltx;"); ok {
+		t.Errorf("Decoding &ltx;, expected an invalid entity, got %s", s)
+	}
+}
+
+func TestMacroPlaceholders(t *testing.T) {
+	fe := NewFakeEngine()
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBmacroEnabled
+	inputMethod := bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	e := NewIbusBambooEngine("test", &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	e.macroTable = &MacroTable{mTable: map[string]string{
+		"hdr": "[{date:2006}] {wmclass}",
+		"sig": "({cursor})",
+		"cb":  "<{clipboard}>",
+	}}
+	e.wmClasses = "thunderbird"
+	for _, key := range "hdr " {
+		e.ProcessKeyEvent(uint32(key), uint32(key), 0)
+	}
+	var expected = fmt.Sprintf("[%d] thunderbird ", time.Now().Year())
+	if fe.commitText != expected {
+		t.Errorf("Expanding placeholders, expected %s, got %s", expected, fe.commitText)
+	}
+	fe.commitText = ""
+	for _, key := range "sig " {
+		e.ProcessKeyEvent(uint32(key), uint32(key), 0)
+	}
+	if fe.commitText != "() " {
+		t.Errorf("Expanding {cursor}, expected the placeholder to be removed, got %s", fe.commitText)
+	}
+	if fe.forwardKeyEvent != [3]uint32{IBusLeft, XkLeft - 8, IBusReleaseMask} {
+		t.Errorf("Expanding {cursor}, expected the caret to be moved left, got %v", fe.forwardKeyEvent)
+	}
+	if text, n := removeMacroCursor(expandMacroPlaceholders("ab{cursor}cd {date:}", strings.ToUpper, "")); n != 2+1+10 || !strings.HasPrefix(text, "ABCD ") {
+		t.Errorf("Removing the cursor mark, expected (ABCD ..., 13), got (%s, %d)", text, n)
+	}
+	// the clipboard is read once the expansion is committed, not while the macro is typed
+	if text := expandMacroPlaceholders("{clipboard}", strings.ToUpper, ""); text != string(macroClipboard) {
+		t.Errorf("Expanding {clipboard}, expected the clipboard mark, got %s", text)
+	}
+	clipboardCache.text, clipboardCache.updatedAt = "copied", time.Now()
+	defer func() { clipboardCache.updatedAt = time.Time{} }()
+	fe.commitText = ""
+	for _, key := range "cb " {
+		e.ProcessKeyEvent(uint32(key), uint32(key), 0)
+	}
+	if fe.commitText != "<copied> " {
+		t.Errorf("Expanding {clipboard}, expected `<copied> `, got %s", fe.commitText)
+	}
+}
+
+func TestMacroSuggestion(t *testing.T) {
+	fe := NewFakeEngine()
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBmacroEnabled | config.IBmacroSuggestion
+	inputMethod := bamboo.ParseInputMethod(cfg.InputMethodDefinitions, cfg.InputMethod)
+	e := NewIbusBambooEngine("test", &cfg, fe, bamboo.NewEngine(inputMethod, cfg.Flags))
+	e.macroTable = &MacroTable{mTable: map[string]string{"btw": "by the way", "bv": "bệnh viện"}}
+	e.ProcessKeyEvent('b', 'b', 0)
+	if !e.isCandidateLTOpened || len(e.candidates) != 2 || e.candidates[0] != "bv" {
+		t.Fatalf("Typing a macro prefix, expected the keys (bv, btw), got %v", e.candidates)
+	}
+	if preview := e.getMacroPreview("btw"); preview != "btw → by the way" {
+		t.Errorf("Previewing macro btw, expected `btw → by the way`, got %s", preview)
+	}
+	if e.ProcessKeyEvent('2', '2', 0); fe.commitText != "b2" {
+		t.Errorf("Typing a digit while the macros are only shown, expected `b2`, got `%s`", fe.commitText)
+	}
+	fe.commitText = ""
+	e.ProcessKeyEvent('b', 'b', 0)
+	e.ProcessKeyEvent(IBusDown, 0, 0)
+	e.ProcessKeyEvent('2', '2', 0)
+	if fe.commitText != "by the way" || e.isCandidateLTOpened {
+		t.Errorf("Selecting the second macro, expected `by the way`, got %s", fe.commitText)
+	}
+	e.macroTable = &MacroTable{mTable: map[string]string{"HNội": "Hà Nội"}}
+	cfg.IBflags &^= config.IBautoCapitalizeMacro
+	fe.commitText = ""
+	for _, key := range "HN" {
+		e.ProcessKeyEvent(uint32(key), uint32(key), 0)
+	}
+	if preview := e.getMacroPreview("HNội"); preview != "HNội → Hà Nội" {
+		t.Errorf("Previewing macro HNội typing HN, expected `HNội → Hà Nội`, got %s", preview)
+	}
+	e.ProcessKeyEvent(IBusDown, 0, 0)
+	e.ProcessKeyEvent(IBusUp, 0, 0)
+	e.ProcessKeyEvent('1', '1', 0)
+	if fe.commitText != "Hà Nội" {
+		t.Errorf("Selecting macro HNội typing HN, expected `Hà Nội`, got %s", fe.commitText)
+	}
+}
+
+func TestAppMacros(t *testing.T) {
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBmacroEnabled
+	cfg.Profiles["emr"] = config.Profile{Macros: "medical"}
+	e, _, typeText := newTestEngine(&cfg)
+	e.macroTable = &MacroTable{
+		enable: true,
+		mTable: map[string]string{"cr": "copyright"},
+		scopes: map[string]*MacroTable{
+			"firefox": {mTable: map[string]string{"lgtm": "looks good to me", "cr": "code review"}},
+			"medical": {mTable: map[string]string{"ha": "huyết áp"}},
+		},
+	}
+	if scopes := cfg.GetMacroScopes("emr:Emr"); len(scopes) != 4 || scopes[0] != "medical" || scopes[1] != "emr:Emr" {
+		t.Errorf("Getting the macro scopes of emr:Emr, expected (medical, emr:Emr, emr, Emr), got %v", scopes)
+	}
+	if path := config.GetAppMacroPath("test", "../../etc/x"); filepath.Dir(path) != config.GetConfigDir("test") {
+		t.Errorf("Getting the macro file of ../../etc/x, expected a file in the config directory, got %s", path)
+	}
+	e.checkWmClass("firefox:Navigator")
+	if text := typeText("lgtm cr "); text != "looks good to me code review " {
+		t.Errorf("Expanding the firefox macros, expected `looks good to me code review `, got `%s`", text)
+	}
+	e.checkWmClass("emr:Emr")
+	if text := typeText("ha cr lgtm "); text != "huyết áp copyright lgtm " {
+		t.Errorf("Expanding the medical macros, expected `huyết áp copyright lgtm `, got `%s`", text)
+	}
+}
+
+func TestMacroCommands(t *testing.T) {
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBmacroEnabled
+	cfg.MacroCommands = []string{"echo"}
+	e, fe, typeText := newTestEngine(&cfg)
+	e.macroTable = &MacroTable{mTable: map[string]string{
+		"br": "!cmd: echo 'feature x'",
+		"id": "!cmd: uuidgen",
+	}}
+	// the commands wait for the keys to be processed
+	var running = make(chan bool)
+	var isFocusMoved = false
+	macroCommandRunner = func(command string, allowed []string, timeout time.Duration) (string, error) {
+		<-running
+		if isFocusMoved {
+			e.FocusOut()
+		}
+		return runMacroCommand(command, allowed, timeout)
+	}
+	defer func() { macroCommandRunner = runMacroCommand }()
+	var expandMacro = func(s string) string {
+		if text := typeText(s); text != "" {
+			t.Errorf("Typing `%s`, expected the keys to be processed before the command finishes, got `%s`", s, text)
+		}
+		running <- true
+		e.macroCommands.Wait()
+		return fe.commitText
+	}
+	if text := expandMacro("br "); text != "feature x " {
+		t.Errorf("Expanding a command macro, expected `feature x `, got `%s`", text)
+	}
+	if text := expandMacro("id "); text != " " {
+		t.Errorf("Expanding a command which isn't allowed, expected nothing, got `%s`", text)
+	}
+	isFocusMoved = true
+	if text := expandMacro("br "); text != "" {
+		t.Errorf("Expanding a command macro after the focus has moved, expected nothing, got `%s`", text)
+	}
+	if _, err := runMacroCommand("sleep 1", []string{"sleep"}, 50*time.Millisecond); err == nil {
+		t.Errorf("Running a command longer than its timeout, expected an error")
+	}
+	dir, err := ioutil.TempDir("", "macro-commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var fakeEcho = filepath.Join(dir, "echo")
+	ioutil.WriteFile(fakeEcho, []byte("#!/bin/sh\necho fake\n"), 0755)
+	if _, err := runMacroCommand(fakeEcho+" x", []string{"echo"}, time.Second); err == nil {
+		t.Errorf("Running %s when echo is allowed, expected an error", fakeEcho)
+	}
+}
+
+func TestEnglishProtection(t *testing.T) {
+	defer saveWordLists()()
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBenglishProtection
+	cfg.Shortcuts[KSRestoreKeyStrokes], cfg.Shortcuts[KSRestoreKeyStrokes+1] = 1, ' '
+	e, _, typeText := newTestEngine(&cfg)
+	englishWords, _ = loadDictionary(DictEnglish)
+	exceptions = NewExceptionList()
+	lexicon = NewLexicon()
+	words, _ := loadDictionary(DictVietnameseCm)
+	lexicon.AddWords(words)
+	if s := typeText("does "); s != "does " {
+		t.Errorf("Typing does, expected `does `, got `%s`", s)
+	}
+	if s := typeText("Does "); s != "Does " {
+		t.Errorf("Typing Does, expected `Does `, got `%s`", s)
+	}
+	if s := typeText("us "); s != "ú " {
+		t.Errorf("Typing us, expected `ú `, got `%s`", s)
+	}
+	englishWords["mots"] = true
+	if s := typeText("mots "); s != "mots " {
+		t.Errorf("Typing mots, expected `mots `, got `%s`", s)
+	}
+	// the pre-edit text mót is switched to its keys and back
+	typeText("mots")
+	e.ProcessKeyEvent(' ', ' ', 1)
+	e.ProcessKeyEvent(' ', ' ', 1)
+	if s := typeText(" "); s != "mót " {
+		t.Errorf("Switching mots to Vietnamese, expected `mót `, got `%s`", s)
+	}
+	if s := typeText("mots "); s != "mót " {
+		t.Errorf("Typing mots after mót was committed, expected `mót `, got `%s`", s)
+	}
+	cfg.IBflags &^= config.IBenglishProtection
+	lexicon = NewLexicon()
+	if s := typeText("does "); s != "dóe " {
+		t.Errorf("Typing does without the English words, expected `dóe `, got `%s`", s)
+	}
+}
+
+// the English words shipped are never Vietnamese words once typed in Telex, e.g. us => ú
+func TestEnglishDictionary(t *testing.T) {
+	englishWords, _ := loadDictionary(DictEnglish)
+	vietnameseWords, _ := loadDictionary(DictVietnameseCm)
+	var inputMethod = bamboo.ParseInputMethod(bamboo.GetInputMethodDefinitions(), "Telex")
+	for word := range englishWords {
+		var preeditor = bamboo.NewEngine(inputMethod, bamboo.EstdFlags)
+		preeditor.ProcessString(word, bamboo.VietnameseMode)
+		var vnSeq = preeditor.GetProcessedString(bamboo.VietnameseMode | bamboo.LowerCase)
+		if bamboo.HasAnyVietnameseRune(vnSeq) && vietnameseWords[vnSeq] {
+			t.Errorf("English word %s is typed as the Vietnamese word %s", word, vnSeq)
+		}
+	}
+}
+
+func TestRestoreKeyStrokes(t *testing.T) {
+	defer saveWordLists()()
+	for _, inputMode := range []int{config.PreeditIM, config.SurroundingTextIM} {
+		var cfg = config.DefaultCfg()
+		cfg.DefaultInputMode = inputMode
+		cfg.Shortcuts[KSRestoreKeyStrokes], cfg.Shortcuts[KSRestoreKeyStrokes+1] = 1, ' '
+		e, fe, _ := newTestEngine(&cfg)
+		exceptions = NewExceptionList()
+		var restore = func() string {
+			e.ProcessKeyEvent(' ', ' ', 1)
+			if inputMode == config.PreeditIM {
+				return fe.preeditText
+			}
+			return fe.commitText
+		}
+		for _, key := range "tieengs" {
+			e.ProcessKeyEvent(uint32(key), uint32(key), 0)
+		}
+		if s := restore(); s != "tieengs" {
+			t.Errorf("Restoring the keys of tiếng in input mode %d, expected tieengs, got `%s`", inputMode, s)
+		}
+		if s := restore(); s != "tiếng" {
+			t.Errorf("Restoring tieengs in input mode %d, expected tiếng, got `%s`", inputMode, s)
+		}
+		if inputMode != config.PreeditIM {
+			e.ProcessKeyEvent(' ', ' ', 0)
+			if s := restore(); s != "tieengs " {
+				t.Errorf("Restoring the keys of the committed tiếng, expected `tieengs `, got `%s`", s)
+			}
+			if s := restore(); s != "tiếng " {
+				t.Errorf("Restoring the committed tieengs, expected `tiếng `, got `%s`", s)
+			}
+		}
+	}
+}
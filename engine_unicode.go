@@ -0,0 +1,147 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"unicode"
+
+	ibus "github.com/BambooEngine/goibus"
+)
+
+const MaxUnicodeSearchResults = 200
+
+func (e *IBusBambooEngine) openUnicodeSearch() {
+	if len(unicodeNames) == 0 {
+		var err error
+		if unicodeNames, err = loadUnicodeNames(DictUnicodeNames); err != nil {
+			log.Println(err)
+		}
+	}
+	if unicodeNameIndex == nil {
+		unicodeNameIndex = NewUnicodeNameIndex(unicodeNames)
+	}
+	e.unicodeQuery = nil
+	e.updateUnicodeCandidates()
+}
+
+func (e *IBusBambooEngine) unicodeProcessKeyEvent(keyVal uint32, keyCode uint32, state uint32) bool {
+	var keyRune = rune(keyVal)
+	if keyVal == IBusEscape {
+		e.closeUnicodeCandidates()
+		return true
+	}
+	if keyVal == IBusReturn {
+		e.commitUnicodeCandidate()
+		e.closeUnicodeCandidates()
+		return true
+	}
+	if keyVal == IBusLeft || keyVal == IBusUp {
+		e.CursorUp()
+		return true
+	} else if keyVal == IBusRight || keyVal == IBusDown {
+		e.CursorDown()
+		return true
+	} else if keyVal == IBusPageUp {
+		e.PageUp()
+		return true
+	} else if keyVal == IBusPageDown {
+		e.PageDown()
+		return true
+	}
+	if keyVal == IBusBackSpace {
+		if len(e.unicodeQuery) == 0 {
+			e.closeUnicodeCandidates()
+			return true
+		}
+		e.unicodeQuery = e.unicodeQuery[:len(e.unicodeQuery)-1]
+	} else if keyRune >= '1' && keyRune <= '9' && len(e.unicodeCandidates) > 0 {
+		if e.updateCursorPosInUnicodeTable(uint32(keyRune - '1')) {
+			e.commitUnicodeCandidate()
+			e.closeUnicodeCandidates()
+		}
+		return true
+	} else if isValidState(state) && (unicode.IsLetter(keyRune) || unicode.IsDigit(keyRune) || keyRune == ' ' || keyRune == '-') {
+		e.unicodeQuery = append(e.unicodeQuery, keyRune)
+	} else {
+		return true
+	}
+	e.updateUnicodeCandidates()
+	return true
+}
+
+func (e *IBusBambooEngine) updateUnicodeCandidates() {
+	e.unicodeCandidates = unicodeNameIndex.Search(string(e.unicodeQuery), MaxUnicodeSearchResults)
+	lt := ibus.NewLookupTable()
+	lt.Orientation = IBusOrientationHorizontal
+	lt.PageSize = uint32(EmojiMaxPageSize)
+	for _, c := range e.unicodeCandidates {
+		lt.AppendCandidate(string(c))
+	}
+	e.unicodeLookupTable = lt
+	e.isUnicodeLTOpened = true
+	e.updateUnicodeLookupTable()
+}
+
+func (e *IBusBambooEngine) updateCursorPosInUnicodeTable(idx uint32) bool {
+	pageSize := e.unicodeLookupTable.PageSize
+	if idx >= pageSize {
+		return false
+	}
+	page := e.unicodeLookupTable.CursorPos / pageSize
+	newPos := page*pageSize + idx
+	if int(newPos) >= len(e.unicodeCandidates) {
+		return false
+	}
+	e.unicodeLookupTable.CursorPos = newPos
+	return true
+}
+
+func (e *IBusBambooEngine) updateUnicodeLookupTable() {
+	var query = string(e.unicodeQuery)
+	var preedit = query
+	var aux = "Unicode: " + query
+	if pos := e.unicodeLookupTable.CursorPos; pos < uint32(len(e.unicodeCandidates)) {
+		var c = e.unicodeCandidates[pos]
+		preedit = string(c)
+		aux += fmt.Sprintf(" | U+%04X %s", c, getUnicodeName(unicodeNames, c))
+	}
+	e.UpdatePreeditTextWithMode(ibus.NewText(preedit), uint32(len([]rune(preedit))), true, ibus.IBUS_ENGINE_PREEDIT_COMMIT)
+	e.UpdateAuxiliaryText(ibus.NewText(aux), true)
+	e.UpdateLookupTable(e.unicodeLookupTable, len(e.unicodeCandidates) > 0)
+}
+
+func (e *IBusBambooEngine) commitUnicodeCandidate() {
+	if pos := e.unicodeLookupTable.CursorPos; pos < uint32(len(e.unicodeCandidates)) {
+		e.CommitText(ibus.NewText(string(e.unicodeCandidates[pos])))
+	}
+}
+
+func (e *IBusBambooEngine) closeUnicodeCandidates() {
+	e.unicodeLookupTable = nil
+	e.unicodeCandidates = nil
+	e.unicodeQuery = nil
+	e.UpdateLookupTable(ibus.NewLookupTable(), true) // workaround for issue #18
+	e.HidePreeditText()
+	e.HideLookupTable()
+	e.HideAuxiliaryText()
+	e.isUnicodeLTOpened = false
+}
@@ -38,7 +38,16 @@ import (
 )
 
 var dictionary = map[string]bool{}
+
+// englishWords holds the common English words kept as typed on word breaks
+var englishWords = map[string]bool{}
+
+var exceptions = NewExceptionList()
+
 var emojiTrie = NewTrie()
+var lexicon = NewLexicon()
+var unicodeNames = map[rune]string{}
+var unicodeNameIndex *UnicodeNameIndex
 
 func GetIBusEngineCreator() func(*dbus.Conn, string) dbus.ObjectPath {
 	go keyPressCapturing()
@@ -78,6 +87,8 @@ func (e *IBusBambooEngine) isShortcutKeyEnable(ski uint) bool {
 func (e *IBusBambooEngine) init() {
 	initConfigFiles(e.engineName)
 	e.emoji = NewEmojiEngine()
+	e.emoji.SkinTone = e.config.EmojiSkinTone
+	emojiUsages.LoadFromFile(config.GetEmojiUsagePath(e.engineName))
 	if e.macroTable == nil {
 		e.macroTable = NewMacroTable(e.config.IBflags&config.IBautoCapitalizeMacro != 0)
 		if e.config.IBflags&config.IBmacroEnabled != 0 {
@@ -146,10 +157,71 @@ func (e *IBusBambooEngine) checkWmClass(newId string) {
 	if e.wmClasses != newId {
 		e.wmClasses = newId
 		e.resetBuffer()
+		if e.macroTable != nil {
+			e.macroTable.SetScopes(e.globalConfig.GetMacroScopes(newId))
+		}
 		e.resetFakeBackspace()
+		// leaving or entering an application with a profile
+		if _, found := e.globalConfig.GetProfile(newId); found || e.config != e.globalConfig {
+			e.applyProfile()
+		}
 	}
 }
 
+// applyProfile merges the profile of the focused application over the global config
+func (e *IBusBambooEngine) applyProfile() {
+	e.config = e.globalConfig.ApplyProfile(e.getWmClass())
+	if e.isSpellCheckDisabledByContentType() {
+		var cfg = *e.config
+		cfg.IBflags &= ^(config.IBspellCheckEnabled | config.IBautoNonVnRestore)
+		e.config = &cfg
+	}
+	e.propList = GetPropListByConfig(e.config)
+	var inputMethod = bamboo.ParseInputMethod(e.config.InputMethodDefinitions, e.config.InputMethod)
+	e.preeditor = bamboo.NewEngine(inputMethod, e.config.Flags)
+	if e.macroTable != nil && e.config.IBflags&config.IBmacroEnabled != 0 && !e.macroTable.enable {
+		e.macroTable.Enable(e.engineName)
+	}
+}
+
+// isContentTypeBypassed tells whether the focused field expects no Vietnamese text, e.g. a password
+func (e *IBusBambooEngine) isContentTypeBypassed() bool {
+	switch e.contentPurpose {
+	case IBusInputPurposeDigits, IBusInputPurposeNumber, IBusInputPurposeUrl, IBusInputPurposeEmail,
+		IBusInputPurposePassword, IBusInputPurposePin:
+		return true
+	}
+	return false
+}
+
+func (e *IBusBambooEngine) isSpellCheckDisabledByContentType() bool {
+	return e.contentPurpose == IBusInputPurposeTerminal || e.contentHints&IBusInputHintNoSpellcheck != 0
+}
+
+// capitalizeSentence returns the key in upper case if it starts a sentence in a field hinted
+// with UPPERCASE_SENTENCES, and remembers whether the next key starts a sentence
+func (e *IBusBambooEngine) capitalizeSentence(keyVal uint32, state uint32) uint32 {
+	if e.contentHints&IBusInputHintUppercaseSentences == 0 || !isValidState(state) {
+		return keyVal
+	}
+	var keyRune = rune(keyVal)
+	switch {
+	case keyRune == '.' || keyRune == '!' || keyRune == '?' || keyVal == IBusReturn:
+		e.isSentenceStart = true
+	case keyRune == ' ' || keyVal == IBusBackSpace:
+	case keyVal >= 0x100:
+		// the keysyms of the function keys, e.g. Left or Shift, aren't characters
+	case unicode.IsLetter(keyRune):
+		if e.isSentenceStart && e.getRawKeyLen() == 0 {
+			keyVal = uint32(unicode.ToUpper(keyRune))
+		}
+		e.isSentenceStart = false
+	default:
+		e.isSentenceStart = false
+	}
+	return keyVal
+}
+
 func (e *IBusBambooEngine) isShortcutKeyPressed(keyVal, state uint32, shortcut uint) bool {
 	if !e.isShortcutKeyEnable(shortcut) {
 		return false
@@ -181,6 +253,15 @@ func (e *IBusBambooEngine) processShortcutKey(keyVal, keyCode, state uint32) (bo
 	if e.isEmojiLTOpened {
 		return true, e.emojiProcessKeyEvent(keyVal, keyCode, state)
 	}
+	if e.isShortcutKeyPressed(keyVal, state, KSUnicodeSearch) && !e.isUnicodeLTOpened {
+		e.resetBuffer()
+		e.lastKeyWithShift = true
+		e.openUnicodeSearch()
+		return true, true
+	}
+	if e.isUnicodeLTOpened {
+		return true, e.unicodeProcessKeyEvent(keyVal, keyCode, state)
+	}
 	// fmt.Println("====== Process hexadecimal key pressed")
 	if e.isShortcutKeyPressed(keyVal, state, KSHexadecimal) {
 		e.resetBuffer()
@@ -202,8 +283,16 @@ func (e *IBusBambooEngine) processShortcutKey(keyVal, keyCode, state uint32) (bo
 	}
 	if e.isShortcutKeyPressed(keyVal, state, KSRestoreKeyStrokes) {
 		// fmt.Println("===== Process restoring key strokes")
-		e.shouldRestoreKeyStrokes = true
-		return false, false
+		return true, e.restoreKeyStrokes()
+	}
+	if e.isShortcutKeyPressed(keyVal, state, KSSpellingSuggestion) {
+		if e.checkInputMode(config.PreeditIM) && e.getRawKeyLen() > 0 {
+			e.openSpellingCandidates("")
+		}
+		return true, true
+	}
+	if e.isShortcutKeyPressed(keyVal, state, KSAddToDictionary) {
+		return true, e.addCurrentWordToDictionary()
 	}
 	// fmt.Println("===Process shortcut for input method switcher")
 	if e.isShortcutKeyPressed(keyVal, state, KSViEnSwitch) {
@@ -360,9 +449,9 @@ func (e *IBusBambooEngine) ltProcessKeyEvent(keyVal uint32, keyCode uint32, stat
 
 func (e *IBusBambooEngine) commitInputModeCandidate() {
 	var im = e.inputModeLookupTable.CursorPos + 1
-	e.config.InputModeMapping[e.getWmClass()] = int(im)
+	e.globalConfig.InputModeMapping[e.getWmClass()] = int(im)
 
-	config.SaveConfig(e.config, e.engineName)
+	config.SaveConfig(e.globalConfig, e.engineName)
 	e.propList = GetPropListByConfig(e.config)
 	e.RegisterProperties(e.propList)
 }
@@ -402,12 +491,6 @@ func (e *IBusBambooEngine) getCommitText(keyVal, keyCode, state uint32) (newText
 	var keyRune = rune(keyVal)
 	isPrintableKey := e.isPrintableKey(state, keyVal)
 	oldText := e.getPreeditString()
-	// restore key strokes by pressing Shift + Space
-	if e.shouldRestoreKeyStrokes {
-		e.shouldRestoreKeyStrokes = false
-		e.preeditor.RestoreLastWord(!bamboo.HasAnyVietnameseRune(oldText))
-		return e.getPreeditString(), false
-	}
 	var keyS string
 	if isPrintableKey {
 		keyS = string(keyRune)
@@ -0,0 +1,198 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"ibus-bamboo/config"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExceptionList holds the key sequences the user has restored after they were
+// converted, so that they are left as typed from then on.
+// It is stored as one `keys count` pair per line, count being how many times
+// the keys have been restored.
+type ExceptionList struct {
+	sync.RWMutex
+	keys     map[string]int
+	fileName string
+	modTime  time.Time
+}
+
+func NewExceptionList() *ExceptionList {
+	return &ExceptionList{keys: map[string]int{}}
+}
+
+func (l *ExceptionList) Has(keys string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	_, found := l.keys[strings.ToLower(keys)]
+	return found
+}
+
+// LoadFromFile reads the list again if the file has changed since it was last read,
+// e.g. after the exceptions have been pruned from the command line.
+// The changes made to the list are saved to the file from then on.
+func (l *ExceptionList) LoadFromFile(fileName string) error {
+	l.Lock()
+	defer l.Unlock()
+	l.fileName = fileName
+	return l.load()
+}
+
+func (l *ExceptionList) load() error {
+	if l.fileName == "" {
+		return nil
+	}
+	info, err := os.Stat(l.fileName)
+	if err != nil {
+		l.keys = map[string]int{}
+		l.modTime = time.Time{}
+		return err
+	}
+	if info.ModTime().Equal(l.modTime) {
+		return nil
+	}
+	f, err := os.Open(l.fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l.keys = map[string]int{}
+	l.modTime = info.ModTime()
+	rd := bufio.NewReader(f)
+	for {
+		line, _, err := rd.ReadLine()
+		if err != nil {
+			break
+		}
+		var fields = strings.Fields(string(line))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var count = 1
+		if len(fields) > 1 {
+			if n, err := strconv.Atoi(fields[1]); err == nil {
+				count = n
+			}
+		}
+		l.keys[strings.ToLower(fields[0])] += count
+	}
+	return nil
+}
+
+func (l *ExceptionList) save() error {
+	if l.fileName == "" {
+		return nil
+	}
+	var sb strings.Builder
+	for _, line := range l.list() {
+		sb.WriteString(line + "\n")
+	}
+	if err := ioutil.WriteFile(l.fileName, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	if info, err := os.Stat(l.fileName); err == nil {
+		l.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Learn records a restored key sequence and saves the list right away,
+// the changes made to the file meanwhile are kept
+func (l *ExceptionList) Learn(keys string) error {
+	keys = strings.ToLower(keys)
+	if keys == "" {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.load()
+	l.keys[keys]++
+	return l.save()
+}
+
+// Remove deletes the key sequences from the list and returns the ones which were found
+func (l *ExceptionList) Remove(keys ...string) ([]string, error) {
+	l.Lock()
+	defer l.Unlock()
+	l.load()
+	var removed []string
+	for _, k := range keys {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if _, found := l.keys[k]; found {
+			delete(l.keys, k)
+			removed = append(removed, k)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, l.save()
+}
+
+// List returns the `keys count` lines of the list, sorted by keys
+func (l *ExceptionList) List() []string {
+	l.RLock()
+	defer l.RUnlock()
+	return l.list()
+}
+
+func (l *ExceptionList) list() []string {
+	var lines []string
+	for k, count := range l.keys {
+		lines = append(lines, fmt.Sprintf("%s %d", k, count))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// listExceptions prints the learned exceptions of an engine
+func listExceptions(engineName string) error {
+	var l = NewExceptionList()
+	if err := l.LoadFromFile(config.GetExceptionsPath(engineName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range l.List() {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// pruneExceptions removes the comma separated key sequences from the learned exceptions of an engine
+func pruneExceptions(keys string, engineName string) error {
+	var l = NewExceptionList()
+	if err := l.LoadFromFile(config.GetExceptionsPath(engineName)); err != nil {
+		return err
+	}
+	removed, err := l.Remove(strings.Split(keys, ",")...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d exception(s): %s\n", len(removed), strings.Join(removed, ", "))
+	return nil
+}
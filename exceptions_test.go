@@ -0,0 +1,82 @@
+package main
+
+import (
+	"ibus-bamboo/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExceptionList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bamboo-exceptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var fileName = filepath.Join(dir, "exceptions.text")
+	var l = NewExceptionList()
+	l.LoadFromFile(fileName)
+	l.Learn("Most")
+	l.Learn("most")
+	l.Learn("does")
+	if lines := l.List(); len(lines) != 2 || lines[0] != "does 1" || lines[1] != "most 2" {
+		t.Errorf("Listing the exceptions, expected [does 1, most 2], got %v", lines)
+	}
+	var other = NewExceptionList()
+	other.LoadFromFile(fileName)
+	if removed, _ := other.Remove("does", "text"); len(removed) != 1 || removed[0] != "does" {
+		t.Errorf("Removing does and text, expected [does], got %v", removed)
+	}
+	if l.Learn("text"); l.Has("does") || !l.Has("most") || !l.Has("text") {
+		t.Errorf("Learning text after does was removed from the file, got %v", l.List())
+	}
+}
+
+func TestLearnExceptions(t *testing.T) {
+	defer saveWordLists()()
+	var cfg = config.DefaultCfg()
+	cfg.Shortcuts[KSRestoreKeyStrokes], cfg.Shortcuts[KSRestoreKeyStrokes+1] = 1, ' '
+	e, _, typeText := newTestEngine(&cfg)
+	exceptions = NewExceptionList()
+	typeText("most")
+	e.ProcessKeyEvent(' ', ' ', 1)
+	if s := typeText(" "); s != "most " || !exceptions.Has("most") {
+		t.Errorf("Restoring the keys of most, expected `most ` to be learned, got `%s`", s)
+	}
+	if s := typeText("most "); s != "most " {
+		t.Errorf("Typing most after it was restored, expected `most `, got `%s`", s)
+	}
+	if s := typeText("tets "); s != "tét " {
+		t.Errorf("Typing tets, expected `tét `, got `%s`", s)
+	}
+	for i := 0; i < 4; i++ {
+		e.ProcessKeyEvent(IBusBackSpace, 0, 0)
+	}
+	if s := typeText("tetss "); s != "tets " || !exceptions.Has("tets") {
+		t.Errorf("Retyping tets after deleting tét, expected `tets ` to be learned, got `%s`", s)
+	}
+	if s := typeText("tets "); s != "tets " {
+		t.Errorf("Typing tets after it was retyped, expected `tets `, got `%s`", s)
+	}
+	if s := typeText("mots x"); s != "mót " || exceptions.Has("mots") {
+		t.Errorf("Typing mots and another word, expected no exception, got `%s`", s)
+	}
+
+	// the backspace input modes
+	cfg.DefaultInputMode = config.SurroundingTextIM
+	e, _, typeText = newTestEngine(&cfg)
+	exceptions = NewExceptionList()
+	if s := typeText("tets "); s != "tét " {
+		t.Errorf("Typing tets in surrounding text mode, expected `tét `, got `%s`", s)
+	}
+	for i := 0; i < 4; i++ {
+		e.ProcessKeyEvent(IBusBackSpace, 0, 0)
+	}
+	if s := typeText("tetss "); s != "tets " || !exceptions.Has("tets") {
+		t.Errorf("Retyping tets after deleting tét in surrounding text mode, expected `tets ` to be learned, got `%s`", s)
+	}
+	if s := typeText("tets "); s != "tets " {
+		t.Errorf("Typing tets after it was retyped in surrounding text mode, expected `tets `, got `%s`", s)
+	}
+}
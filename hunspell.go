@@ -0,0 +1,252 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the vi_VN dictionary of hunspell-vi, as installed by the distributions
+var hunspellViDicts = []string{
+	"/usr/share/hunspell/vi_VN.dic",
+	"/usr/share/myspell/vi_VN.dic",
+	"/usr/share/myspell/dicts/vi_VN.dic",
+}
+
+// hunspellAffix is a PFX or SFX rule of a Hunspell .aff file: strip is removed
+// from the word and add is put in its place if the word matches the condition
+type hunspellAffix struct {
+	strip     string
+	add       string
+	condition *regexp.Regexp
+}
+
+// HunspellAffixes holds the affix rules of a Hunspell .aff file by their flags
+type HunspellAffixes struct {
+	flagType      string
+	prefixes      map[string][]hunspellAffix
+	suffixes      map[string][]hunspellAffix
+	crossProduct  map[string]bool
+	needAffix     string
+	forbiddenWord string
+}
+
+func NewHunspellAffixes() *HunspellAffixes {
+	return &HunspellAffixes{
+		prefixes:     map[string][]hunspellAffix{},
+		suffixes:     map[string][]hunspellAffix{},
+		crossProduct: map[string]bool{},
+	}
+}
+
+// loadHunspellAffixes reads the FLAG, NEEDAFFIX, FORBIDDENWORD, PFX and SFX
+// lines of a Hunspell .aff file, the other options don't change the words
+func loadHunspellAffixes(affFile string) (*HunspellAffixes, error) {
+	f, err := os.Open(affFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var a = NewHunspellAffixes()
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var fields = strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "FLAG":
+			a.flagType = fields[1]
+		case "NEEDAFFIX":
+			a.needAffix = fields[1]
+		case "FORBIDDENWORD":
+			a.forbiddenWord = fields[1]
+		case "PFX", "SFX":
+			var isPrefix = fields[0] == "PFX"
+			// the header of a rule group, e.g. SFX A Y 2
+			if len(fields) == 4 {
+				if _, err := strconv.Atoi(fields[3]); err == nil {
+					a.crossProduct[fields[1]] = fields[2] == "Y"
+					continue
+				}
+			}
+			if len(fields) < 4 {
+				continue
+			}
+			var affix = hunspellAffix{strip: fields[2], add: fields[3]}
+			if affix.strip == "0" {
+				affix.strip = ""
+			}
+			// the continuation classes of twofold affixes are left out
+			if i := strings.IndexRune(affix.add, '/'); i >= 0 {
+				affix.add = affix.add[:i]
+			}
+			if affix.add == "0" {
+				affix.add = ""
+			}
+			if len(fields) > 4 && fields[4] != "." {
+				var pattern = "(" + fields[4] + ")$"
+				if isPrefix {
+					pattern = "^(" + fields[4] + ")"
+				}
+				if affix.condition, err = regexp.Compile(pattern); err != nil {
+					continue
+				}
+			}
+			if isPrefix {
+				a.prefixes[fields[1]] = append(a.prefixes[fields[1]], affix)
+			} else {
+				a.suffixes[fields[1]] = append(a.suffixes[fields[1]], affix)
+			}
+		}
+	}
+	return a, scanner.Err()
+}
+
+// parseFlags splits the flags of a .dic entry as the FLAG option of the .aff file says
+func (a *HunspellAffixes) parseFlags(flags string) []string {
+	var list []string
+	switch a.flagType {
+	case "long":
+		var chars = []rune(flags)
+		for i := 0; i+1 < len(chars); i += 2 {
+			list = append(list, string(chars[i:i+2]))
+		}
+	case "num":
+		for _, flag := range strings.Split(flags, ",") {
+			if flag != "" {
+				list = append(list, flag)
+			}
+		}
+	default:
+		for _, c := range flags {
+			list = append(list, string(c))
+		}
+	}
+	return list
+}
+
+// Expand returns the word and the forms its affix flags make of it,
+// e.g. work/S with `SFX S 0 s .` => work, works
+func (a *HunspellAffixes) Expand(word string, flags []string) []string {
+	var words []string
+	var needAffix = false
+	for _, flag := range flags {
+		if flag == a.forbiddenWord {
+			return nil
+		}
+		if flag == a.needAffix {
+			needAffix = true
+		}
+	}
+	if !needAffix {
+		words = append(words, word)
+	}
+	var suffixed []string
+	for _, flag := range flags {
+		for _, affix := range a.suffixes[flag] {
+			if w, ok := affix.applySuffix(word); ok {
+				words = append(words, w)
+				if a.crossProduct[flag] {
+					suffixed = append(suffixed, w)
+				}
+			}
+		}
+	}
+	for _, flag := range flags {
+		for _, affix := range a.prefixes[flag] {
+			if w, ok := affix.applyPrefix(word); ok {
+				words = append(words, w)
+			}
+			if !a.crossProduct[flag] {
+				continue
+			}
+			for _, s := range suffixed {
+				if w, ok := affix.applyPrefix(s); ok {
+					words = append(words, w)
+				}
+			}
+		}
+	}
+	return words
+}
+
+func (affix hunspellAffix) applySuffix(word string) (string, bool) {
+	if !strings.HasSuffix(word, affix.strip) || len(word) == len(affix.strip) {
+		return "", false
+	}
+	if affix.condition != nil && !affix.condition.MatchString(word) {
+		return "", false
+	}
+	return word[:len(word)-len(affix.strip)] + affix.add, true
+}
+
+func (affix hunspellAffix) applyPrefix(word string) (string, bool) {
+	if !strings.HasPrefix(word, affix.strip) || len(word) == len(affix.strip) {
+		return "", false
+	}
+	if affix.condition != nil && !affix.condition.MatchString(word) {
+		return "", false
+	}
+	return affix.add + word[len(affix.strip):], true
+}
+
+// loadHunspellDictionary adds the words of a Hunspell .dic file to data, their affixes
+// are expanded with the rules of the .aff file next to it if there is one
+func loadHunspellDictionary(dicFile string, data map[string]bool) error {
+	var affixes = NewHunspellAffixes()
+	if a, err := loadHunspellAffixes(strings.TrimSuffix(dicFile, ".dic") + ".aff"); err == nil {
+		affixes = a
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.Open(dicFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var scanner = bufio.NewScanner(f)
+	var firstLine = true
+	for scanner.Scan() {
+		var fields = strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		// the first line is the approximate word count
+		if firstLine {
+			firstLine = false
+			if _, err := strconv.Atoi(fields[0]); err == nil {
+				continue
+			}
+		}
+		var word, flags = fields[0], ""
+		if i := strings.IndexRune(word, '/'); i > 0 {
+			word, flags = word[:i], word[i+1:]
+		}
+		for _, w := range affixes.Expand(word, affixes.parseFlags(flags)) {
+			data[strings.ToLower(w)] = true
+		}
+	}
+	return scanner.Err()
+}
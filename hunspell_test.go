@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadHunspellDictionary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hunspell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "test.aff"), []byte(`SET UTF-8
+FORBIDDENWORD !
+NEEDAFFIX ?
+
+SFX S Y 2
+SFX S y ies [^aeiou]y
+SFX S 0 s [^y]
+
+PFX R Y 1
+PFX R 0 re .
+`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "test.dic"), []byte(`5
+Tiếng
+copy/SR
+work/RS
+cancel/?S
+xoong/!
+`), 0644)
+	var plain = filepath.Join(dir, "plain.dict")
+	ioutil.WriteFile(plain, []byte("boong\n"), 0644)
+	words, err := loadDictionary(filepath.Join(dir, "test.dic"), filepath.Join(dir, "test.aff"), plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"tiếng", "copy", "copies", "recopy", "recopies", "work", "works", "rework", "reworks", "cancels", "boong"} {
+		if !words[w] {
+			t.Errorf("Loading test.dic, expected %s", w)
+		}
+	}
+	for _, w := range []string{"5", "copys", "cancel", "xoong", "test.aff"} {
+		if words[w] {
+			t.Errorf("Loading test.dic, unexpected %s", w)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "long.aff"), []byte("FLAG long\nSFX Aa N 1\nSFX Aa 0 er .\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "long.dic"), []byte("1\nwork/AaBb\n"), 0644)
+	if words, err = loadDictionary(filepath.Join(dir, "long.dic")); err != nil || !words["worker"] || len(words) != 2 {
+		t.Errorf("Loading long.dic with long flags, expected [work worker], got %v", words)
+	}
+}
@@ -54,6 +54,35 @@ const (
 	//IBUS_CAP_PROPERTY         = 1 << 4 //UI is capable to have property.
 	IBusCapSurroundingText = 1 << 5 //Client can provide surround text, or IME can handle surround text.
 )
+const (
+	//IBusInputPurpose
+	IBusInputPurposeFreeForm = iota
+	IBusInputPurposeAlpha
+	IBusInputPurposeDigits
+	IBusInputPurposeNumber
+	IBusInputPurposePhone
+	IBusInputPurposeUrl
+	IBusInputPurposeEmail
+	IBusInputPurposeName
+	IBusInputPurposePassword
+	IBusInputPurposePin
+	IBusInputPurposeTerminal
+)
+const (
+	//IBusInputHints
+	IBusInputHintSpellcheck         = 1 << 0
+	IBusInputHintNoSpellcheck       = 1 << 1
+	IBusInputHintWordCompletion     = 1 << 2
+	IBusInputHintLowercase          = 1 << 3
+	IBusInputHintUppercaseChars     = 1 << 4
+	IBusInputHintUppercaseWords     = 1 << 5
+	IBusInputHintUppercaseSentences = 1 << 6
+	IBusInputHintInhibitOsk         = 1 << 7
+	IBusInputHintVerticalWriting    = 1 << 8
+	IBusInputHintEmoji              = 1 << 9
+	IBusInputHintNoEmoji            = 1 << 10
+	IBusInputHintPrivate            = 1 << 11
+)
 const (
 	XkBackspace = 0x16
 	XkLeft      = 0x71
@@ -0,0 +1,304 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+// Lexicon holds the words used for completion, together with how often the
+// user has typed each of them.
+// The user's part of the lexicon is stored as one `word count` pair per line,
+// a `previous word count` line records a word picked right after another one.
+// The base frequencies rank the words the user hasn't picked yet, they aren't saved.
+type Lexicon struct {
+	sync.RWMutex
+	trie     *TrieNode
+	freq     map[string]int
+	base     map[string]int
+	toneless map[string][]string
+	contexts map[string]int
+	dirty    bool
+}
+
+func NewLexicon() *Lexicon {
+	return &Lexicon{
+		trie:     NewTrie(),
+		freq:     map[string]int{},
+		base:     map[string]int{},
+		toneless: map[string][]string{},
+		contexts: map[string]int{},
+	}
+}
+
+func (l *Lexicon) IsEmpty() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return len(l.freq) == 0
+}
+
+func (l *Lexicon) HasWord(word string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	_, found := l.freq[strings.ToLower(word)]
+	return found
+}
+
+// GetFrequency returns how many times the user has typed word
+func (l *Lexicon) GetFrequency(word string) int {
+	l.RLock()
+	defer l.RUnlock()
+	return l.freq[strings.ToLower(word)]
+}
+
+func (l *Lexicon) addWord(word string, count int) {
+	if _, found := l.freq[word]; !found {
+		InsertTrie(l.trie, word, word)
+		var key = removeDiacritics(word)
+		l.toneless[key] = append(l.toneless[key], word)
+	}
+	l.freq[word] += count
+}
+
+func (l *Lexicon) AddWords(words map[string]bool) {
+	l.Lock()
+	defer l.Unlock()
+	for word := range words {
+		l.addWord(word, 0)
+	}
+}
+
+func (l *Lexicon) LoadFromFile(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l.Lock()
+	defer l.Unlock()
+	rd := bufio.NewReader(f)
+	for {
+		line, _, err := rd.ReadLine()
+		if err != nil {
+			break
+		}
+		word, count, ok := parseLexiconLine(string(line))
+		if !ok {
+			continue
+		}
+		if strings.Contains(word, " ") {
+			l.contexts[word] += count
+		} else {
+			l.addWord(word, count)
+		}
+	}
+	return nil
+}
+
+// LoadBaseFrequencies reads the `word count` lines of the frequencies shipped with ibus-bamboo
+func (l *Lexicon) LoadBaseFrequencies(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l.Lock()
+	defer l.Unlock()
+	rd := bufio.NewReader(f)
+	for {
+		line, _, err := rd.ReadLine()
+		if err != nil {
+			break
+		}
+		word, count, ok := parseLexiconLine(string(line))
+		if !ok || strings.Contains(word, " ") {
+			continue
+		}
+		l.addWord(word, 0)
+		l.base[word] = count
+	}
+	return nil
+}
+
+// parseLexiconLine splits a `word count` line, the count is 1 if it's left out
+func parseLexiconLine(line string) (string, int, bool) {
+	var s = strings.TrimSpace(line)
+	if len(s) == 0 || strings.HasPrefix(s, "#") {
+		return "", 0, false
+	}
+	var word, count = s, 1
+	if i := strings.LastIndexAny(s, " \t"); i > 0 {
+		if n, err := strconv.Atoi(s[i+1:]); err == nil {
+			word, count = strings.TrimSpace(s[:i]), n
+		}
+	}
+	return strings.ToLower(word), count, true
+}
+
+// SaveToFile writes the words the user has typed at least once and the learned contexts.
+func (l *Lexicon) SaveToFile(fileName string) error {
+	l.Lock()
+	defer l.Unlock()
+	if !l.dirty {
+		return nil
+	}
+	var words []string
+	for word, count := range l.freq {
+		if count > 0 {
+			words = append(words, word)
+		}
+	}
+	for context := range l.contexts {
+		words = append(words, context)
+	}
+	sort.Strings(words)
+	var sb strings.Builder
+	for _, word := range words {
+		var count = l.freq[word]
+		if strings.Contains(word, " ") {
+			count = l.contexts[word]
+		}
+		sb.WriteString(fmt.Sprintf("%s %d\n", word, count))
+	}
+	if err := ioutil.WriteFile(fileName, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// Learn increases the frequency of a word, adding it to the lexicon if needed.
+func (l *Lexicon) Learn(word string) {
+	word = strings.ToLower(word)
+	if word == "" {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.addWord(word, 1)
+	l.dirty = true
+}
+
+// Complete returns at most limit words starting with prefix, the most
+// frequent words first. The prefix itself is never returned.
+func (l *Lexicon) Complete(prefix string, limit int) []string {
+	prefix = strings.ToLower(prefix)
+	l.RLock()
+	defer l.RUnlock()
+	var words []string
+	for word := range FindPrefix(l.trie, prefix) {
+		if word != prefix {
+			words = append(words, word)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		var fi, fj = l.freq[words[i]], l.freq[words[j]]
+		if fi != fj {
+			return fi > fj
+		}
+		var li, lj = utf8.RuneCountInString(words[i]), utf8.RuneCountInString(words[j])
+		if li != lj {
+			return li < lj
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > limit {
+		words = words[:limit]
+	}
+	return words
+}
+
+// LearnContext remembers that word was picked right after the previous one.
+func (l *Lexicon) LearnContext(previous, word string) {
+	if previous == "" || word == "" {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.contexts[strings.ToLower(previous+" "+word)]++
+	l.dirty = true
+}
+
+// Restore returns at most limit words which are written as text once their
+// diacritics are removed, the words most often picked after previous first.
+func (l *Lexicon) Restore(text, previous string, limit int) []string {
+	text = strings.ToLower(text)
+	previous = strings.ToLower(previous)
+	l.RLock()
+	defer l.RUnlock()
+	var key = removeDiacritics(text)
+	var words = append([]string(nil), l.toneless[key]...)
+	sort.Slice(words, func(i, j int) bool {
+		if r := l.compareRank(previous, words[i], words[j]); r != 0 {
+			return r > 0
+		}
+		// the words written without diacritics come last, then the ones
+		// without a tone mark come first, e.g. không before khống
+		if words[i] == key || words[j] == key {
+			return words[j] == key
+		}
+		var ti, tj = hasToneMark(words[i]), hasToneMark(words[j])
+		if ti != tj {
+			return !ti
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > limit {
+		words = words[:limit]
+	}
+	return words
+}
+
+// IsPreferred tells if word is picked after previous, typed or frequent more than other
+func (l *Lexicon) IsPreferred(previous, word, other string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.compareRank(strings.ToLower(previous), strings.ToLower(word), strings.ToLower(other)) > 0
+}
+
+// compareRank returns a positive number if a ranks above b, a negative one if b ranks above a
+func (l *Lexicon) compareRank(previous, a, b string) int {
+	if ca, cb := l.contexts[previous+" "+a], l.contexts[previous+" "+b]; ca != cb {
+		return ca - cb
+	}
+	if fa, fb := l.freq[a], l.freq[b]; fa != fb {
+		return fa - fb
+	}
+	return l.base[a] - l.base[b]
+}
+
+func hasToneMark(word string) bool {
+	for _, c := range word {
+		if bamboo.FindToneFromChar(c) != bamboo.ToneNone {
+			return true
+		}
+	}
+	return false
+}
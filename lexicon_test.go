@@ -0,0 +1,187 @@
+package main
+
+import (
+	"ibus-bamboo/config"
+	"testing"
+)
+
+func TestLexiconComplete(t *testing.T) {
+	var l = NewLexicon()
+	l.AddWords(map[string]bool{"nghiêng": true, "nghĩ": true, "nghe": true, "ngà": true})
+	l.Learn("nghiêng")
+	var words = l.Complete("ngh", 10)
+	if len(words) != 3 {
+		t.Fatalf("Completing ngh, expected 3 words, got %v", words)
+	}
+	if words[0] != "nghiêng" {
+		t.Errorf("Completing ngh, expected the most frequent word first, got %s", words[0])
+	}
+	if words[1] != "nghe" || words[2] != "nghĩ" {
+		t.Errorf("Completing ngh, expected [nghe nghĩ] after nghiêng, got %v", words[1:])
+	}
+	if words = l.Complete("nghe", 10); len(words) != 0 {
+		t.Errorf("Completing nghe, expected no words, got %v", words)
+	}
+}
+
+func TestApplyCaseOf(t *testing.T) {
+	if s := applyCaseOf("Ngh", "nghiêng"); s != "Nghiêng" {
+		t.Errorf("Applying case of Ngh, expected Nghiêng, got %s", s)
+	}
+	if s := applyCaseOf("NGH", "nghiêng"); s != "NGHIÊNG" {
+		t.Errorf("Applying case of NGH, expected NGHIÊNG, got %s", s)
+	}
+	if s := applyCaseOf("N", "nghiêng"); s != "Nghiêng" {
+		t.Errorf("Applying case of N, expected Nghiêng, got %s", s)
+	}
+}
+
+func TestPreeditCompletion(t *testing.T) {
+	defer saveWordLists()()
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBwordCompletion
+	e, fe, typeText := newTestEngine(&cfg)
+	lexicon = NewLexicon()
+	lexicon.AddWords(map[string]bool{"nghiêng": true, "nghĩ": true, "xe": true})
+	// the space is passed through to the client
+	if s := typeText("x2 "); s != "x2" {
+		t.Errorf("Typing x2 while xe is listed, expected `x2`, got `%s`", s)
+	}
+	fe.commitText = ""
+	for _, c := range "ngh" {
+		e.ProcessKeyEvent(uint32(c), uint32(c), 0)
+	}
+	if !e.isCandidateLTOpened || len(e.candidates) != 2 {
+		t.Fatalf("Typing ngh, expected 2 candidates, got %v", e.candidates)
+	}
+	e.ProcessKeyEvent(IBusDown, 0, 0)
+	if ret, _ := e.ProcessKeyEvent('2', '2', 0); !ret {
+		t.Errorf("Selecting the second candidate, expected the key to be processed")
+	}
+	if fe.commitText != "nghiêng" {
+		t.Errorf("Selecting the second candidate, expected commit text nghiêng, got %s", fe.commitText)
+	}
+	if e.isCandidateLTOpened {
+		t.Errorf("Selecting a candidate, expected the lookup table to be closed")
+	}
+
+	// the digits are the tones of VNI until the user browses the candidates
+	cfg.InputMethod = "VNI"
+	e, fe, typeText = newTestEngine(&cfg)
+	if s := typeText("nghie6ng1"); s != "" || fe.preeditText != "nghiếng" {
+		t.Errorf("Typing nghie6ng1 in VNI, expected preedit nghiếng, got `%s`", fe.preeditText)
+	}
+	e.Reset()
+	typeText("ngh")
+	e.ProcessKeyEvent(IBusDown, 0, 0)
+	var second = e.candidates[1]
+	if s := typeText("2"); s != second {
+		t.Errorf("Selecting the second candidate in VNI, expected commit text %s, got `%s`", second, s)
+	}
+}
+
+func TestLexiconRestore(t *testing.T) {
+	var l = NewLexicon()
+	l.AddWords(map[string]bool{"không": true, "khống": true, "khóng": true, "khong": true, "có": true})
+	var words = l.Restore("khong", "", 10)
+	if len(words) != 4 || words[0] != "không" || words[3] != "khong" {
+		t.Errorf("Restore(khong) = %v", words)
+	}
+	l.LearnContext("có", "khống")
+	if words = l.Restore("khong", "có", 10); words[0] != "khống" {
+		t.Errorf("Restore(khong) after có = %v", words)
+	}
+}
+
+func TestToneLessTyping(t *testing.T) {
+	defer saveWordLists()()
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBtoneLessTyping
+	_, _, typeText := newTestEngine(&cfg)
+	lexicon = NewLexicon()
+	words, _ := loadDictionary(DictVietnameseCm)
+	lexicon.AddWords(words)
+	if err := lexicon.LoadBaseFrequencies(DictVietnameseFreq); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range [][2]string{{"toi ", "tôi "}, {"Viet ", "Việt "}, {"khong ", "không "}} {
+		if s := typeText(tc[0]); s != tc[1] {
+			t.Errorf("Typing `%s` without diacritics, expected `%s`, got `%s`", tc[0], tc[1], s)
+		}
+	}
+	// the space is passed through to the client
+	if s := typeText("a1 "); s != "a1" {
+		t.Errorf("Typing a1, expected `a1`, got `%s`", s)
+	}
+	lexicon = NewLexicon()
+	lexicon.AddWords(map[string]bool{"khống": true, "khóng": true})
+	if s := typeText("khong "); s != "khong " {
+		t.Errorf("Typing khong when no word is more likely, expected `khong `, got `%s`", s)
+	}
+}
+
+func TestLexiconCorrect(t *testing.T) {
+	var l = NewLexicon()
+	l.AddWords(map[string]bool{"nghiêng": true, "nghiêm": true, "tươi": true, "tuổi": true, "tàp": true})
+	l.Learn("nghiêng")
+	if words := l.Correct("nghiêg", 10); len(words) != 2 || words[0] != "nghiêng" || words[1] != "nghiêm" {
+		t.Errorf("Correcting nghiêg, expected [nghiêng nghiêm], got %v", words)
+	}
+	if words := l.Correct("tuơi", 10); len(words) != 2 || words[0] != "tươi" {
+		t.Errorf("Correcting tuơi, expected tươi first, got %v", words)
+	}
+	l.AddWords(map[string]bool{"thơi": true, "tơi": true, "trơi": true})
+	if words := l.Correct("tuơi", 10); len(words) < 1 || words[0] != "tươi" {
+		t.Errorf("Correcting tuơi along with the words a letter away, expected tươi first, got %v", words)
+	}
+	if !isValidSyllable("Tiếng") || isValidSyllable("tieéng") {
+		t.Errorf("Checking the syllables tiếng and tieéng, expected only tiếng to be valid")
+	}
+}
+
+func TestSpellingRules(t *testing.T) {
+	for _, word := range []string{"tàp", "mãc", "ka", "ce", "ngi", "gha", "qa", "tòt", "TÀP", "Ka"} {
+		if checkSpellingRules(word, false) || isValidSyllable(word) {
+			t.Errorf("Checking %s, expected an invalid syllable", word)
+		}
+	}
+	for _, word := range []string{"tắt", "mạch", "ké", "kỉ", "nghe", "gì", "giêng", "quốc", "Ất", "Ích", "HỌC"} {
+		if !checkSpellingRules(word, true) || !isValidSyllable(word) {
+			t.Errorf("Checking %s, expected a valid syllable", word)
+		}
+	}
+	if !checkSpellingRules("hoc", false) || checkSpellingRules("hoc", true) {
+		t.Errorf("Checking hoc, expected the tone to be required only for a complete word")
+	}
+}
+
+func TestSpellingSuggestion(t *testing.T) {
+	defer saveWordLists()()
+	var cfg = config.DefaultCfg()
+	cfg.IBflags |= config.IBspellingSuggestion
+	e, _, typeText := newTestEngine(&cfg)
+	lexicon = NewLexicon()
+	lexicon.AddWords(map[string]bool{"nghiêng": true, "nghiêm": true})
+	if s := typeText("Nghieeg "); !e.isCandidateLTOpened || len(e.candidates) != 2 || s != "" {
+		t.Fatalf("Typing Nghieeg and a space, expected 2 corrections, got %v", e.candidates)
+	}
+	if s := typeText("2"); s != "Nghiêng " {
+		t.Errorf("Selecting the second correction, expected `Nghiêng `, got `%s`", s)
+	}
+	typeText("nghieeg ")
+	e.ProcessKeyEvent(IBusEscape, 0, 0)
+	if s := typeText(" "); e.isCandidateLTOpened || s != "nghieeg " {
+		t.Errorf("Dismissing the corrections, expected the space to commit `nghieeg `, got `%s`", s)
+	}
+	if s := typeText("nghieeg "); !e.isCandidateLTOpened || s != "" {
+		t.Errorf("Typing nghieeg again, expected the corrections, got `%s`", s)
+	}
+	e.ProcessKeyEvent(IBusEscape, 0, 0)
+	typeText(" ")
+	if s := typeText("nghieeng "); e.isCandidateLTOpened || s != "nghiêng " {
+		t.Errorf("Typing a valid word, expected it to be committed, got `%s`", s)
+	}
+	if s := typeText("HOJC "); e.isCandidateLTOpened || s != "HỌC " {
+		t.Errorf("Typing a valid word in capitals, expected it to be committed, got `%s`", s)
+	}
+}
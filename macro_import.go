@@ -0,0 +1,289 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"ibus-bamboo/config"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+const (
+	macroFileHeader        = "# DO NOT DELETE THIS LINE*** version=2 ***"
+	unikeyMacroFileHeader  = ";DO NOT DELETE THIS LINE*** version=1 ***"
+	unicodeComposedCharset = "Unicode tổ hợp"
+)
+
+// the charsets the macro files of UniKey, EVKey and OpenKey are detected in when they are not UTF-8
+var legacyMacroCharsets = []string{"TCVN3 (ABC)", "VNI Windows", "VISCII", "VPS", "Windows 1258 codepage"}
+
+// windows1252 maps the bytes 0x80-0x9f to the runes the charset tables use for them
+var windows1252 = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ',
+	0x89: '‰', 0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ', 0x8e: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“',
+	0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›',
+	0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+}
+
+func bytesToWindows1252(data []byte) string {
+	var runes = make([]rune, len(data))
+	for i, b := range data {
+		if r, found := windows1252[b]; found {
+			runes[i] = r
+		} else {
+			runes[i] = rune(b)
+		}
+	}
+	return string(runes)
+}
+
+func windows1252ToBytes(s string) []byte {
+	var reversed = map[rune]byte{}
+	for b, r := range windows1252 {
+		reversed[r] = b
+	}
+	var data []byte
+	for _, r := range s {
+		if b, found := reversed[r]; found {
+			data = append(data, b)
+		} else if r < 0x100 {
+			data = append(data, byte(r))
+		} else {
+			data = append(data, '?')
+		}
+	}
+	return data
+}
+
+// decodeCharset converts a text encoded by bamboo.Encode back to Unicode, it returns the number of
+// runes which were decoded into Vietnamese letters along with the text
+func decodeCharset(text, charset string) (string, int) {
+	var table = map[string]rune{}
+	var maxLen = 0
+	for _, base := range "aăâeêioôơuưyAĂÂEÊIOÔƠUƯYđĐ" {
+		for tone := uint8(0); tone <= 5; tone++ {
+			var chr = bamboo.AddToneToChar(base, tone)
+			if out := bamboo.Encode(charset, string(chr)); out != string(chr) {
+				table[out] = chr
+				if n := utf8.RuneCountInString(out); n > maxLen {
+					maxLen = n
+				}
+			}
+		}
+	}
+	var runes = []rune(text)
+	var sb strings.Builder
+	var nDecoded = 0
+	for i := 0; i < len(runes); {
+		var found = false
+		for n := maxLen; n > 0; n-- {
+			if i+n > len(runes) {
+				continue
+			}
+			if chr, ok := table[string(runes[i:i+n])]; ok {
+				sb.WriteRune(chr)
+				nDecoded += n
+				i += n
+				found = true
+				break
+			}
+		}
+		if !found {
+			sb.WriteRune(runes[i])
+			i++
+		}
+	}
+	return sb.String(), nDecoded
+}
+
+// decodeMacroData returns the text of a macro file and its charset, which is detected if it is empty
+func decodeMacroData(data []byte, charset string) (string, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		data = data[3:]
+	case len(data) >= 2 && (data[0] == 0xff && data[1] == 0xfe || data[0] == 0xfe && data[1] == 0xff):
+		var bigEndian = data[0] == 0xfe
+		var units = make([]uint16, 0, len(data)/2)
+		for i := 2; i+1 < len(data); i += 2 {
+			if bigEndian {
+				units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+			} else {
+				units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+			}
+		}
+		data = []byte(string(utf16.Decode(units)))
+	}
+	if charset == "" && utf8.Valid(data) {
+		charset = bamboo.UNICODE
+	}
+	if charset == bamboo.UNICODE || charset == unicodeComposedCharset {
+		// the letters written with combining marks are recomposed
+		var text, _ = decodeCharset(string(data), unicodeComposedCharset)
+		return text, charset
+	}
+	var text = bytesToWindows1252(data)
+	if charset != "" {
+		var decoded, _ = decodeCharset(text, charset)
+		return decoded, charset
+	}
+	var best, bestCount = text, -1
+	for _, cs := range legacyMacroCharsets {
+		if decoded, n := decodeCharset(text, cs); n > bestCount {
+			best, bestCount, charset = decoded, n, cs
+		}
+	}
+	return best, charset
+}
+
+// importMacros adds the macros of a UniKey, EVKey or OpenKey file to the macro file of the engine,
+// the keys which are already there are reported and left untouched
+func importMacros(fileName, charset, engineName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	text, charset := decodeMacroData(data, charset)
+	imported, errs := parseMacros(strings.NewReader(text))
+	for _, err := range errs {
+		fmt.Printf("%s:%s\n", fileName, err)
+	}
+	var macroPath = config.GetMacroPath(engineName)
+	content, err := ioutil.ReadFile(macroPath)
+	if os.IsNotExist(err) {
+		content, err = ioutil.ReadFile(getEngineSubFile(sampleMactabFile))
+		if err != nil {
+			content = []byte(macroFileHeader + "\n")
+		}
+	} else if err != nil {
+		return err
+	}
+	existing, _ := parseMacros(bytes.NewReader(content))
+	var keys []string
+	for key := range imported {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines []string
+	var nDuplicates, nConflicts int
+	for _, key := range keys {
+		if text, found := existing[key]; found {
+			if text == imported[key] {
+				nDuplicates++
+				fmt.Printf("Duplicate: %s\n", key)
+			} else {
+				nConflicts++
+				fmt.Printf("Conflict: %s is %q, not %q, kept the existing text\n", key, text, imported[key])
+			}
+			continue
+		}
+		lines = append(lines, formatMacroLine(key, imported[key]))
+	}
+	var sb strings.Builder
+	sb.WriteString(upgradeMacroFile(string(content)))
+	if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteString("\n")
+	}
+	for _, line := range lines {
+		sb.WriteString(line + "\n")
+	}
+	if err = os.MkdirAll(config.GetConfigDir(engineName), 0777); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(macroPath, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d macros from %s (%s) into %s, %d duplicates and %d conflicts skipped\n",
+		len(lines), fileName, charset, macroPath, nDuplicates, nConflicts)
+	return nil
+}
+
+// upgradeMacroFile rewrites the entries of a version 1 macro file in the version 2 format
+func upgradeMacroFile(content string) string {
+	var lines = strings.Split(content, "\n")
+	if len(lines) > 0 && strings.Contains(lines[0], "version=2") {
+		return content
+	}
+	for i, line := range lines {
+		var s = strings.TrimSpace(line)
+		if i == 0 && strings.Contains(s, "version=1") {
+			lines[i] = strings.Replace(line, "version=1", "version=2", 1)
+			continue
+		}
+		if len(s) == 0 || strings.HasPrefix(s, ";") || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if list := strings.SplitN(s, ":", 2); len(list) == 2 {
+			lines[i] = formatMacroLine(strings.TrimSpace(list[0]), strings.TrimSpace(list[1]))
+		}
+	}
+	if !strings.Contains(lines[0], "version=2") {
+		lines = append([]string{macroFileHeader}, lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// exportMacros writes the macros of the engine in the UniKey format, which EVKey and OpenKey read too
+func exportMacros(fileName, charset, engineName string) error {
+	var macroPath = config.GetMacroPath(engineName)
+	f, err := os.Open(macroPath)
+	if err != nil {
+		return err
+	}
+	mTable, _ := parseMacros(f)
+	f.Close()
+	var keys []string
+	for key := range mTable {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines = []string{unikeyMacroFileHeader}
+	for _, key := range keys {
+		var text = mTable[key]
+		if strings.ContainsAny(key, ":\r\n") || strings.ContainsAny(text, "\r\n") || text != strings.TrimFunc(text, unicode.IsSpace) {
+			fmt.Printf("Skipped: %s can't be written in the UniKey format\n", key)
+			continue
+		}
+		lines = append(lines, key+":"+text)
+	}
+	var content = strings.Join(lines, "\r\n") + "\r\n"
+	var data []byte
+	if charset == "" || charset == bamboo.UNICODE {
+		charset = bamboo.UNICODE
+		data = append([]byte{0xef, 0xbb, 0xbf}, content...)
+	} else if charset == unicodeComposedCharset {
+		data = []byte(bamboo.Encode(charset, content))
+	} else {
+		data = windows1252ToBytes(bamboo.Encode(charset, content))
+	}
+	if err = ioutil.WriteFile(fileName, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d macros to %s (%s)\n", len(lines)-1, fileName, charset)
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const macroPollingInterval = 3 * time.Second
+
+// a fileWatcher calls its callback with the path of the files which change in the watched directories
+type fileWatcher interface {
+	Watch(dir string) error
+}
+
+// MacroWatcher reloads the macro tables of all the engines when their files change
+type MacroWatcher struct {
+	sync.Mutex
+	tables  map[*MacroTable]string
+	dirs    map[string]bool
+	watcher fileWatcher
+}
+
+var macroWatcher = &MacroWatcher{
+	tables: map[*MacroTable]string{},
+	dirs:   map[string]bool{},
+}
+
+// Add reloads the table from macroFile whenever the file changes
+func (w *MacroWatcher) Add(table *MacroTable, macroFile string) {
+	w.Lock()
+	defer w.Unlock()
+	w.tables[table] = macroFile
+	var dir = filepath.Dir(macroFile)
+	if w.dirs[dir] {
+		return
+	}
+	w.dirs[dir] = true
+	if w.watcher == nil {
+		var err error
+		if w.watcher, err = newFileWatcher(w.reload); err != nil {
+			log.Println("Watching the macro files by polling:", err)
+			w.watcher = newPollingWatcher(w.reload, macroPollingInterval)
+		}
+	}
+	// the directory is watched since editors usually replace the files instead of writing them
+	if err := w.watcher.Watch(dir); err != nil {
+		log.Println("Failed to watch the macro files:", err)
+		w.dirs[dir] = false
+	}
+}
+
+func (w *MacroWatcher) Remove(table *MacroTable) {
+	w.Lock()
+	defer w.Unlock()
+	delete(w.tables, table)
+}
+
+func (w *MacroWatcher) reload(path string) {
+	w.Lock()
+	var tables []*MacroTable
+	for table, macroFile := range w.tables {
+		if macroFile == path {
+			tables = append(tables, table)
+		}
+	}
+	w.Unlock()
+	for _, table := range tables {
+		// the macros of a deleted file are dropped
+		if err := table.LoadFromFile(path); os.IsNotExist(err) {
+			table.clearMacros()
+		}
+	}
+}
+
+// pollingWatcher compares the modification times of the files, it's used where inotify isn't available
+type pollingWatcher struct {
+	sync.Mutex
+	dirs     []string
+	modTimes map[string]time.Time
+	onChange func(string)
+}
+
+func newPollingWatcher(onChange func(string), interval time.Duration) *pollingWatcher {
+	var w = &pollingWatcher{modTimes: map[string]time.Time{}, onChange: onChange}
+	go func() {
+		for {
+			time.Sleep(interval)
+			w.poll()
+		}
+	}()
+	return w
+}
+
+func (w *pollingWatcher) Watch(dir string) error {
+	w.Lock()
+	defer w.Unlock()
+	w.dirs = append(w.dirs, dir)
+	w.scan(dir, false)
+	return nil
+}
+
+func (w *pollingWatcher) poll() {
+	w.Lock()
+	var changed []string
+	for _, dir := range w.dirs {
+		changed = append(changed, w.scan(dir, true)...)
+	}
+	w.Unlock()
+	for _, path := range changed {
+		w.onChange(path)
+	}
+}
+
+// scan records the modification times of the files in dir and returns the changed or deleted ones
+func (w *pollingWatcher) scan(dir string, notify bool) []string {
+	var changed []string
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var found = map[string]bool{}
+	for _, f := range files {
+		var path = filepath.Join(dir, f.Name())
+		found[path] = true
+		if modTime, ok := w.modTimes[path]; !ok || !modTime.Equal(f.ModTime()) {
+			w.modTimes[path] = f.ModTime()
+			if notify {
+				changed = append(changed, path)
+			}
+		}
+	}
+	for path := range w.modTimes {
+		if filepath.Dir(path) == dir && !found[path] {
+			delete(w.modTimes, path)
+			if notify {
+				changed = append(changed, path)
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,88 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_DELETE
+
+type inotifyWatcher struct {
+	sync.Mutex
+	fd       int
+	dirs     map[int]string
+	onChange func(string)
+}
+
+func newFileWatcher(onChange func(string)) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var w = &inotifyWatcher{fd: fd, dirs: map[int]string{}, onChange: onChange}
+	go w.run()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Watch(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.Lock()
+	w.dirs[wd] = dir
+	w.Unlock()
+	return nil
+}
+
+func (w *inotifyWatcher) run() {
+	var buf [syscall.SizeofInotifyEvent * 256]byte
+	for {
+		n, err := syscall.Read(w.fd, buf[:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			log.Println("Stopped watching the macro files:", err)
+			return
+		}
+		var changed = map[string]bool{}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			var event = (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			var name = buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			w.Lock()
+			var dir, found = w.dirs[int(event.Wd)]
+			w.Unlock()
+			if found && event.Len > 0 {
+				changed[filepath.Join(dir, string(bytes.TrimRight(name, "\x00")))] = true
+			}
+		}
+		for path := range changed {
+			w.onChange(path)
+		}
+	}
+}
@@ -21,51 +21,251 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"ibus-bamboo/config"
+	"io"
+	"log"
 	"os"
+	"os/exec"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	macroBlockStart = "<<<"
+	macroBlockEnd   = ">>>"
+)
+
+// macroCursor marks the caret position of an expansion until it is committed
+const macroCursor = '\uE000'
+
+// macroClipboard marks where the clipboard goes in an expansion, it's read once the expansion is committed
+const macroClipboard = '\uE001'
+
+// the command of a `!cmd:` macro is enclosed in these marks until it is committed
+const (
+	macroCommandPrefix = "!cmd:"
+	macroCommandStart  = '\uE001'
+	macroCommandEnd    = '\uE002'
+)
+
+// placeholders look like {name} or {name:argument}, e.g. {date:02/01/2006}
+var macroPlaceholderRegex = regexp.MustCompile(`\{(date|time|clipboard|wmclass|cursor)(?::([^{}]*))?\}`)
+
+var clipboardCache struct {
+	sync.Mutex
+	text      string
+	updatedAt time.Time
+}
+
 type MacroTable struct {
 	sync.RWMutex
 	enable              bool
 	autoCapitalizeMacro bool
 	mTable              map[string]string
+	// keys indexes the keys of mTable for the prefix lookups, it is built on the first lookup
+	keys       *TrieNode
+	engineName string
+	// the tables of the focused application are looked up before this one
+	scopes       map[string]*MacroTable
+	activeScopes []string
+	activeTables []*MacroTable
 }
 
 func NewMacroTable(autoCapitalizeMacro bool) *MacroTable {
 	return &MacroTable{autoCapitalizeMacro: autoCapitalizeMacro}
 }
 
+// LoadFromFile reads a macro file, the entries which can't be parsed are logged with their line numbers
 func (e *MacroTable) LoadFromFile(macroFileName string) error {
 	f, err := os.Open(macroFileName)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	e.mTable = map[string]string{}
-	rd := bufio.NewReader(f)
-	for {
-		line, _, err := rd.ReadLine()
+	var normalizeKey = func(key string) string { return key }
+	if e.autoCapitalizeMacro {
+		// the keys are looked up in lower case, of the keys which differ only
+		// in case the last one of the file wins as a repeated key does
+		normalizeKey = strings.ToLower
+	}
+	mTable, errs := readMacros(f, normalizeKey)
+	for _, err := range errs {
+		log.Printf("%s:%s\n", macroFileName, err)
+	}
+	e.Lock()
+	e.mTable = mTable
+	e.keys = nil
+	e.Unlock()
+	return nil
+}
+
+// parseMacros reads the `key:text` lines of a macro file.
+// Since version 2, which is declared in a comment like the one of the template, the keys and texts
+// may contain the escape sequences \: \n \t and \\, a text may be quoted to keep its spaces
+// and a text spanning several lines is written between `key:<<<` and a `>>>` line
+func parseMacros(r io.Reader) (map[string]string, []error) {
+	return readMacros(r, func(key string) string { return key })
+}
+
+// readMacros parses a macro file, normalizeKey gives the key of an entry in the table
+func readMacros(r io.Reader, normalizeKey func(string) string) (map[string]string, []error) {
+	var mTable = map[string]string{}
+	var errs []error
+	var version = 1
+	var blockKey string
+	var blockLine int
+	var block []string
+	var inBlock bool
+	var scanner = bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var line = scanner.Text()
+		if inBlock {
+			if strings.TrimSpace(line) == macroBlockEnd {
+				mTable[normalizeKey(blockKey)] = strings.Join(block, "\n")
+				inBlock = false
+			} else {
+				block = append(block, line)
+			}
+			continue
+		}
+		var s = strings.TrimSpace(line)
+		if len(s) == 0 || strings.HasPrefix(s, ";") || strings.HasPrefix(s, "#") {
+			if lineNum == 1 && strings.Contains(s, "version=2") {
+				version = 2
+			}
+			continue
+		}
+		if version < 2 {
+			// the values may contain ':' as in {time:15:04}
+			var list = strings.SplitN(s, ":", 2)
+			if len(list) != 2 || strings.TrimSpace(list[0]) == "" {
+				errs = append(errs, fmt.Errorf("%d: expected `key:text`, got %q", lineNum, s))
+				continue
+			}
+			mTable[normalizeKey(strings.TrimSpace(list[0]))] = strings.TrimSpace(list[1])
+			continue
+		}
+		key, text, err := parseMacroLine(s)
 		if err != nil {
-			break
+			errs = append(errs, fmt.Errorf("%d: %s", lineNum, err))
+			continue
 		}
-		var s = strings.TrimSpace(string(line))
-		if len(line) == 0 || strings.HasPrefix(s, ";") || strings.HasPrefix(s, "#") {
+		if text == macroBlockStart {
+			blockKey, blockLine, block, inBlock = key, lineNum, nil, true
 			continue
 		}
-		var list = strings.Split(s, ":")
-		if len(list) == 2 {
-			key := strings.TrimSpace(list[0])
-			if e.autoCapitalizeMacro {
-				key = strings.ToLower(key)
+		mTable[normalizeKey(key)] = text
+	}
+	if inBlock {
+		errs = append(errs, fmt.Errorf("%d: the text of %q has no closing %s", blockLine, blockKey, macroBlockEnd))
+	}
+	return mTable, errs
+}
+
+// parseMacroLine splits a version 2 line at its first unescaped ':' and unescapes both parts
+func parseMacroLine(s string) (string, string, error) {
+	var sep = -1
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == ':' {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 {
+		return "", "", fmt.Errorf("expected `key:text`, got %q", s)
+	}
+	key, err := unescapeMacroText(strings.TrimSpace(s[:sep]))
+	if err != nil {
+		return "", "", err
+	}
+	if key == "" {
+		return "", "", fmt.Errorf("the key of %q is empty", s)
+	}
+	var rawText = strings.TrimSpace(s[sep+1:])
+	if rawText == macroBlockStart {
+		return key, rawText, nil
+	}
+	if strings.HasPrefix(rawText, `"`) {
+		var end = -1
+		for i := 1; i < len(rawText); i++ {
+			if rawText[i] == '\\' {
+				i++
+			} else if rawText[i] == '"' {
+				end = i
+				break
 			}
-			e.mTable[key] = strings.TrimSpace(list[1])
 		}
+		if end < 0 {
+			return "", "", fmt.Errorf("the text of %q has no closing quote", key)
+		}
+		if end != len(rawText)-1 {
+			return "", "", fmt.Errorf("unexpected %q after the quoted text of %q", rawText[end+1:], key)
+		}
+		rawText = rawText[1:end]
 	}
-	return nil
+	text, err := unescapeMacroText(rawText)
+	if err != nil {
+		return "", "", err
+	}
+	return key, text, nil
+}
+
+// formatMacroLine writes an entry in the version 2 format read by parseMacros
+func formatMacroLine(key, text string) string {
+	var keyEscaper = strings.NewReplacer(`\`, `\\`, ":", `\:`, "\n", `\n`, "\t", `\t`)
+	key = keyEscaper.Replace(key)
+	if strings.Contains(text, "\n") && !strings.Contains(text, "\r") {
+		var isBlock = true
+		for _, line := range strings.Split(text, "\n") {
+			if strings.TrimSpace(line) == macroBlockEnd {
+				isBlock = false
+			}
+		}
+		if isBlock {
+			return key + ":" + macroBlockStart + "\n" + text + "\n" + macroBlockEnd
+		}
+	}
+	var textEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`)
+	var escaped = textEscaper.Replace(text)
+	if escaped != strings.TrimSpace(escaped) || strings.HasPrefix(escaped, `"`) || escaped == macroBlockStart {
+		escaped = `"` + strings.Replace(escaped, `"`, `\"`, -1) + `"`
+	}
+	return key + ":" + escaped
+}
+
+func unescapeMacroText(s string) (string, error) {
+	if !strings.ContainsRune(s, '\\') {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", fmt.Errorf("%q ends with a lone backslash", s)
+		}
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case ':', '\\', '"':
+			sb.WriteByte(s[i])
+		default:
+			return "", fmt.Errorf("unknown escape sequence \\%c in %q", s[i], s)
+		}
+	}
+	return sb.String(), nil
 }
 
 func (e *MacroTable) Reload(engineName string, autoCapitalizeMacro bool) {
@@ -74,52 +274,346 @@ func (e *MacroTable) Reload(engineName string, autoCapitalizeMacro bool) {
 }
 
 func (e *MacroTable) GetText(key string) string {
+	for _, table := range e.getScopeTables() {
+		if text := table.getText(key); text != "" {
+			return text
+		}
+	}
+	return e.getText(key)
+}
+
+func (e *MacroTable) getText(key string) string {
 	if e.autoCapitalizeMacro {
 		key = strings.ToLower(key)
 	}
+	e.RLock()
+	defer e.RUnlock()
 	return e.mTable[key]
 }
 
+// SetScopes chooses the application and profile macro files layered over the global one,
+// it's called when the focused application changes and loads the files on their first use
+func (e *MacroTable) SetScopes(scopes []string) {
+	var tables []*MacroTable
+	for _, scope := range scopes {
+		if table := e.getScopeTable(scope); table != nil {
+			tables = append(tables, table)
+		}
+	}
+	e.Lock()
+	e.activeScopes = scopes
+	e.activeTables = tables
+	e.Unlock()
+}
+
+func (e *MacroTable) getScopeTable(scope string) *MacroTable {
+	e.RLock()
+	var table, found = e.scopes[scope]
+	e.RUnlock()
+	if found || !e.enable || e.engineName == "" {
+		return table
+	}
+	var path = config.GetAppMacroPath(e.engineName, scope)
+	table = NewMacroTable(e.autoCapitalizeMacro)
+	table.LoadFromFile(path)
+	// the file is watched even if it doesn't exist yet
+	macroWatcher.Add(table, path)
+	e.Lock()
+	if e.scopes == nil {
+		e.scopes = map[string]*MacroTable{}
+	}
+	e.scopes[scope] = table
+	e.Unlock()
+	return table
+}
+
+// getScopeTables returns the tables of the active scopes, the ones of the focused application
+func (e *MacroTable) getScopeTables() []*MacroTable {
+	e.RLock()
+	defer e.RUnlock()
+	return e.activeTables
+}
+
+func (e *MacroTable) clearScopes() {
+	e.Lock()
+	defer e.Unlock()
+	for _, table := range e.scopes {
+		macroWatcher.Remove(table)
+	}
+	e.scopes = nil
+	e.activeTables = nil
+}
+
 func (e *MacroTable) HasKey(key string) bool {
-	if e.autoCapitalizeMacro {
-		key = strings.ToLower(key)
+	return e.GetText(key) != ""
+}
+
+func (e *MacroTable) getKeys() *TrieNode {
+	e.RLock()
+	var keys = e.keys
+	e.RUnlock()
+	if keys != nil {
+		return keys
 	}
-	return e.mTable[key] != ""
+	e.Lock()
+	defer e.Unlock()
+	if e.keys == nil {
+		e.keys = NewTrie()
+		for k, text := range e.mTable {
+			if text != "" {
+				InsertTrie(e.keys, k, "")
+			}
+		}
+	}
+	return e.keys
 }
 
 func (e *MacroTable) HasPrefix(key string) bool {
-	if e.mTable[key] != "" {
-		return true
-	}
-	for k := range e.mTable {
-		if strings.HasPrefix(k, key) {
+	for _, table := range e.getScopeTables() {
+		if table.hasPrefix(key) {
 			return true
 		}
 	}
-	return false
+	return e.hasPrefix(key)
 }
 
+func (e *MacroTable) hasPrefix(key string) bool {
+	var node = e.getKeys()
+	for _, c := range key {
+		if node = node.Children[c]; node == nil {
+			return false
+		}
+	}
+	// every node but the root is on the path of a key
+	return key != "" || len(node.Children) > 0
+}
+
+// GetKeysWithPrefix returns at most limit keys starting with prefix, the shortest ones first
+func (e *MacroTable) GetKeysWithPrefix(prefix string, limit int) []string {
+	if e.autoCapitalizeMacro {
+		prefix = strings.ToLower(prefix)
+	}
+	var keys []string
+	var found = map[string]bool{}
+	for _, table := range append(e.getScopeTables(), e) {
+		for k := range FindPrefix(table.getKeys(), prefix) {
+			if !found[k] {
+				found[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// Enable loads the macro file of the engine, which is reloaded by the shared watcher when it changes
 func (e *MacroTable) Enable(engineName string) {
 	e.enable = true
+	e.engineName = engineName
+	// the application tables are reloaded with the current settings
+	e.clearScopes()
+	var efPath = config.GetMacroPath(engineName)
+	e.LoadFromFile(efPath)
+	macroWatcher.Add(e, efPath)
+	e.RLock()
+	var scopes = e.activeScopes
+	e.RUnlock()
+	e.SetScopes(scopes)
+}
 
-	go func() {
-		modTime := time.Now()
+// expandMacroPlaceholders replaces the placeholders of a macro text, adjustCase is applied to the
+// text around them so that the layouts of dates and times are kept intact
+func expandMacroPlaceholders(text string, adjustCase func(string) string, wmClass string) string {
+	var sb strings.Builder
+	var last = 0
+	for _, loc := range macroPlaceholderRegex.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(adjustCase(text[last:loc[0]]))
+		last = loc[1]
+		var name, arg = text[loc[2]:loc[3]], ""
+		if loc[4] >= 0 {
+			arg = text[loc[4]:loc[5]]
+		}
+		switch name {
+		case "date":
+			if arg == "" {
+				arg = "02/01/2006"
+			}
+			sb.WriteString(time.Now().Format(arg))
+		case "time":
+			if arg == "" {
+				arg = "15:04"
+			}
+			sb.WriteString(time.Now().Format(arg))
+		case "clipboard":
+			sb.WriteRune(macroClipboard)
+		case "wmclass":
+			sb.WriteString(wmClass)
+		case "cursor":
+			sb.WriteRune(macroCursor)
+		}
+	}
+	sb.WriteString(adjustCase(text[last:]))
+	return sb.String()
+}
 
-		efPath := config.GetMacroPath(engineName)
+// insertMacroClipboard puts the text of the clipboard in place of its marks
+func insertMacroClipboard(text string) string {
+	if !strings.ContainsRune(text, macroClipboard) {
+		return text
+	}
+	return strings.Replace(text, string(macroClipboard), getClipboardText(), -1)
+}
 
-		for e.enable {
-			if sta, _ := os.Stat(efPath); sta != nil {
-				if newModeTime := sta.ModTime(); !newModeTime.Equal(modTime) {
-					modTime = newModeTime
-					e.LoadFromFile(efPath)
-				}
+// removeMacroCursor returns the text without the cursor mark and the number of runes after it
+func removeMacroCursor(text string) (string, int) {
+	var i = strings.LastIndex(text, string(macroCursor))
+	if i < 0 {
+		return text, 0
+	}
+	var nRight = len([]rune(text[i:])) - 1
+	return strings.Replace(text, string(macroCursor), "", -1), nRight
+}
+
+// splitMacroCommands splits a text at the commands of `!cmd:` macros, the commands are at the odd indexes
+func splitMacroCommands(text string) []string {
+	var parts []string
+	for {
+		var start = strings.IndexRune(text, macroCommandStart)
+		var end = strings.IndexRune(text, macroCommandEnd)
+		if start < 0 || end < start {
+			return append(parts, text)
+		}
+		parts = append(parts, text[:start], text[start+len(string(macroCommandStart)):end])
+		text = text[end+len(string(macroCommandEnd)):]
+	}
+}
+
+// removeMacroCommands returns a text without the commands of `!cmd:` macros
+func removeMacroCommands(text string) string {
+	var parts = splitMacroCommands(text)
+	var sb strings.Builder
+	for i := 0; i < len(parts); i += 2 {
+		sb.WriteString(parts[i])
+	}
+	return sb.String()
+}
+
+// splitCommandLine splits a command at its spaces, except the ones in quotes
+func splitCommandLine(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var inArg bool
+	var quote rune
+	for _, c := range command {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
 			}
-			time.Sleep(3 * time.Second)
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote in %q", command)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// runMacroCommand runs the command of a `!cmd:` macro if its program is allowed, its output without
+// the trailing newlines is the expansion
+func runMacroCommand(command string, allowed []string, timeout time.Duration) (string, error) {
+	args, err := splitCommandLine(command)
+	if err != nil {
+		return "", err
+	}
+	// the program is compared by its path, so that a program of the same name elsewhere can't be run
+	program, err := exec.LookPath(args[0])
+	if err != nil {
+		return "", err
+	}
+	var isAllowed = false
+	for _, name := range allowed {
+		if path, err := exec.LookPath(name); err == nil && path == program {
+			isAllowed = true
+			break
+		}
+	}
+	if !isAllowed {
+		return "", fmt.Errorf("%s is not in the allowed macro commands", args[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, program, args[1:]...).Output()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("%s timed out after %s", command, timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", command, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// getClipboardText reads the clipboard with the tools of the display server, the text is cached for
+// a second since several expansions could be committed at once
+func getClipboardText() string {
+	clipboardCache.Lock()
+	defer clipboardCache.Unlock()
+	if time.Since(clipboardCache.updatedAt) < time.Second {
+		return clipboardCache.text
+	}
+	var commands = [][]string{{"xclip", "-selection", "clipboard", "-o"}, {"xsel", "-b", "-o"}}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append([][]string{{"wl-paste", "-n"}}, commands...)
+	}
+	clipboardCache.text = ""
+	for _, command := range commands {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		out, err := exec.CommandContext(ctx, command[0], command[1:]...).Output()
+		cancel()
+		if err == nil {
+			clipboardCache.text = string(out)
+			break
 		}
-	}()
+	}
+	clipboardCache.updatedAt = time.Now()
+	return clipboardCache.text
 }
 
 func (e *MacroTable) Disable() {
 	e.enable = false
+	macroWatcher.Remove(e)
+	e.clearScopes()
+	e.clearMacros()
+}
+
+func (e *MacroTable) clearMacros() {
+	e.Lock()
 	e.mTable = map[string]string{}
+	e.keys = nil
+	e.Unlock()
 }
@@ -0,0 +1,196 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+func TestParseMacros(t *testing.T) {
+	var mTable, errs = parseMacros(strings.NewReader(`# DO NOT DELETE THIS LINE*** version=2 ***
+url:https://example.com
+a\:b : c\td
+quoted:"  x : y  "
+sig:<<<
+Regards,
+  Lam
+>>>
+broken line
+bad:"unclosed
+esc:\q
+`))
+	var expected = map[string]string{
+		"url":    "https://example.com",
+		"a:b":    "c\td",
+		"quoted": "  x : y  ",
+		"sig":    "Regards,\n  Lam",
+	}
+	for key, text := range expected {
+		if mTable[key] != text {
+			t.Errorf("Parsing macro %q, expected %q, got %q", key, text, mTable[key])
+		}
+	}
+	if len(mTable) != len(expected) {
+		t.Errorf("Parsing macros, expected %d entries, got %v", len(expected), mTable)
+	}
+	if len(errs) != 3 || !strings.HasPrefix(errs[0].Error(), "9: ") {
+		t.Errorf("Parsing macros, expected 3 errors from line 9, got %v", errs)
+	}
+
+	mTable, errs = parseMacros(strings.NewReader("# DO NOT DELETE THIS LINE*** version=1 ***\nt:{time:15:04}\nx\n"))
+	if mTable["t"] != "{time:15:04}" || len(errs) != 1 {
+		t.Errorf("Parsing version 1 macros, expected t and 1 error, got %v %v", mTable, errs)
+	}
+}
+
+func TestFormatMacroLine(t *testing.T) {
+	var entries = map[string]string{
+		"a:b":    "x\\y",
+		"sig":    "Regards,\n  Lam",
+		"pad":    "  padded  ",
+		"q":      `"quoted"`,
+		"end":    "a\n>>>",
+		"tabbed": "a\tb",
+	}
+	var sb strings.Builder
+	sb.WriteString(macroFileHeader + "\n")
+	for key, text := range entries {
+		sb.WriteString(formatMacroLine(key, text) + "\n")
+	}
+	var mTable, errs = parseMacros(strings.NewReader(sb.String()))
+	if len(errs) != 0 {
+		t.Errorf("Parsing formatted macros, expected no error, got %v", errs)
+	}
+	for key, text := range entries {
+		if mTable[key] != text {
+			t.Errorf("Formatting macro %q, expected %q, got %q", key, text, mTable[key])
+		}
+	}
+	var upgraded = upgradeMacroFile(unikeyMacroFileHeader + "\nurl:C:\\path\n")
+	if mTable, _ = parseMacros(strings.NewReader(upgraded)); mTable["url"] != "C:\\path" {
+		t.Errorf("Upgrading a version 1 file, expected url, got %q from %q", mTable["url"], upgraded)
+	}
+}
+
+func TestDecodeMacroData(t *testing.T) {
+	var tcvn3 = windows1252ToBytes(bamboo.Encode("TCVN3 (ABC)", "vn:Việt Nam\r\nhn:Hà Nội"))
+	if text, charset := decodeMacroData(tcvn3, ""); charset != "TCVN3 (ABC)" || text != "vn:Việt Nam\r\nhn:Hà Nội" {
+		t.Errorf("Decoding a TCVN3 file, expected (Việt Nam, TCVN3), got (%s, %s)", text, charset)
+	}
+	var vni = windows1252ToBytes(bamboo.Encode("VNI Windows", "vn:Việt Nam"))
+	if text, charset := decodeMacroData(vni, ""); charset != "VNI Windows" || text != "vn:Việt Nam" {
+		t.Errorf("Decoding a VNI file, expected (Việt Nam, VNI Windows), got (%s, %s)", text, charset)
+	}
+	var utf8WithBOM = append([]byte{0xef, 0xbb, 0xbf}, "vn:Việt Nam"...)
+	if text, charset := decodeMacroData(utf8WithBOM, ""); charset != bamboo.UNICODE || text != "vn:Việt Nam" {
+		t.Errorf("Decoding a UTF-8 file, expected (Việt Nam, Unicode), got (%s, %s)", text, charset)
+	}
+}
+
+func TestMacroTablePrefix(t *testing.T) {
+	var table = &MacroTable{mTable: map[string]string{"vn": "Việt Nam", "vnd": "đồng"}}
+	for prefix, expected := range map[string]bool{"": true, "v": true, "vn": true, "vnd": true, "vnx": false, "x": false} {
+		if table.HasPrefix(prefix) != expected {
+			t.Errorf("Checking macro prefix %q, expected %v", prefix, expected)
+		}
+	}
+	if keys := table.GetKeysWithPrefix("v", 10); len(keys) != 2 || keys[0] != "vn" {
+		t.Errorf("Listing the macros of prefix v, expected (vn, vnd), got %v", keys)
+	}
+	if (&MacroTable{}).HasPrefix("") {
+		t.Errorf("Checking the empty prefix of an empty table, expected false")
+	}
+}
+
+func TestLoadMacrosInLowerCase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "macro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var macroFile = filepath.Join(dir, "macro.text")
+	ioutil.WriteFile(macroFile, []byte("HN:Hà Nội\nvn:Việt Nam\nhn:hà nội\nVN:VIỆT NAM\n"), 0644)
+	var table = NewMacroTable(true)
+	if err := table.LoadFromFile(macroFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.mTable) != 2 || table.mTable["hn"] != "hà nội" || table.mTable["vn"] != "VIỆT NAM" {
+		t.Errorf("Loading keys which differ in case, expected the last ones of the file, got %v", table.mTable)
+	}
+}
+
+func TestMacroWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "macro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var macroFile = filepath.Join(dir, "ibus-test.macro.text")
+	ioutil.WriteFile(macroFile, []byte("vn:Việt Nam\n"), 0644)
+	var table = NewMacroTable(false)
+	table.LoadFromFile(macroFile)
+	macroWatcher.Add(table, macroFile)
+	defer macroWatcher.Remove(table)
+
+	var polled = make(chan string, 10)
+	var poller = newPollingWatcher(func(path string) { polled <- path }, 50*time.Millisecond)
+	poller.Watch(dir)
+
+	time.Sleep(20 * time.Millisecond)
+	ioutil.WriteFile(macroFile, []byte("vn:Việt Nam\nhn:Hà Nội\n"), 0644)
+	var deadline = time.Now().Add(2 * time.Second)
+	for !table.HasKey("hn") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !table.HasKey("hn") || !table.HasPrefix("h") {
+		t.Errorf("Changing the macro file, expected the table to be reloaded")
+	}
+	select {
+	case path := <-polled:
+		if path != macroFile {
+			t.Errorf("Polling the macro dir, expected %s, got %s", macroFile, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Polling the macro dir, expected the change to be noticed")
+	}
+
+	os.Remove(macroFile)
+	deadline = time.Now().Add(2 * time.Second)
+	for table.HasKey("vn") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if table.HasKey("vn") {
+		t.Errorf("Deleting the macro file, expected the macros to be dropped")
+	}
+	select {
+	case path := <-polled:
+		if path != macroFile {
+			t.Errorf("Polling the macro dir, expected %s to be deleted, got %s", macroFile, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Polling the macro dir, expected the deletion to be noticed")
+	}
+}
@@ -37,6 +37,11 @@ const (
 var embedded = flag.Bool("ibus", false, "Run the embedded ibus component")
 var version = flag.Bool("version", false, "Show version")
 var gui = flag.Bool("gui", false, "Show GUI")
+var importMacrosFile = flag.String("import-macros", "", "Import the macros of a UniKey, EVKey or OpenKey file")
+var exportMacrosFile = flag.String("export-macros", "", "Export the macros to a file UniKey, EVKey and OpenKey can read")
+var macroCharset = flag.String("macro-charset", "", "The charset of the imported or exported macro file, detected when importing if empty")
+var listExceptionsFlag = flag.Bool("list-exceptions", false, "List the key sequences learned from the restored words")
+var pruneExceptionsKeys = flag.String("prune-exceptions", "", "Remove the comma separated key sequences from the learned exceptions")
 var isWayland = false
 var isGnome = false
 
@@ -60,6 +65,22 @@ func main() {
 	}
 	if *version {
 		fmt.Println(Version)
+	} else if *importMacrosFile != "" {
+		if err := importMacros(*importMacrosFile, *macroCharset, strings.ToLower(EngineName)); err != nil {
+			log.Fatal(err)
+		}
+	} else if *exportMacrosFile != "" {
+		if err := exportMacros(*exportMacrosFile, *macroCharset, strings.ToLower(EngineName)); err != nil {
+			log.Fatal(err)
+		}
+	} else if *listExceptionsFlag {
+		if err := listExceptions(strings.ToLower(EngineName)); err != nil {
+			log.Fatal(err)
+		}
+	} else if *pruneExceptionsKeys != "" {
+		if err := pruneExceptions(*pruneExceptionsKeys, strings.ToLower(EngineName)); err != nil {
+			log.Fatal(err)
+		}
 	} else if *embedded {
 		engine := GetIBusEngineCreator()
 		bus := ibus.NewBus()
@@ -46,6 +46,12 @@ const (
 	PropKeyAutoCapitalizeMacro          = "auto_capitalize_macro"
 	PropKeyIMQuickSwitchEnabled         = "im_quick_switch"
 	PropKeyRestoreKeyStrokes            = "restore_key_strokes"
+	PropKeyWordCompletion               = "word_completion"
+	PropKeyToneLessTyping               = "tone_less_typing"
+	PropKeyReconversion                 = "reconversion"
+	PropKeyMacroSuggestion              = "macro_suggestion"
+	PropKeySpellingSuggestion           = "spelling_suggestion"
+	PropKeyEnglishProtection            = "english_protection"
 )
 
 var IBusSeparator = &ibus.Property{
@@ -260,6 +266,7 @@ func GetIMPropListByConfig(c *config.Config) *ibus.PropList {
 func GetMacroPropListByConfig(c *config.Config) *ibus.PropList {
 	macroChecked := ibus.PROP_STATE_UNCHECKED
 	autoCapitalizeMacro := ibus.PROP_STATE_UNCHECKED
+	macroSuggestionChecked := ibus.PROP_STATE_UNCHECKED
 
 	if c.IBflags&config.IBmacroEnabled != 0 {
 		macroChecked = ibus.PROP_STATE_CHECKED
@@ -267,6 +274,9 @@ func GetMacroPropListByConfig(c *config.Config) *ibus.PropList {
 	if c.IBflags&config.IBautoCapitalizeMacro != 0 {
 		autoCapitalizeMacro = ibus.PROP_STATE_CHECKED
 	}
+	if c.IBflags&config.IBmacroSuggestion != 0 {
+		macroSuggestionChecked = ibus.PROP_STATE_CHECKED
+	}
 	return ibus.NewPropList(
 		&ibus.Property{
 			Name:      "IBusProperty",
@@ -292,6 +302,18 @@ func GetMacroPropListByConfig(c *config.Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("C")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyMacroSuggestion,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Gợi ý gõ tắt")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("List the macros starting with the typed text (Pre-edit)")),
+			Sensitive: true,
+			Visible:   true,
+			State:     macroSuggestionChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("G")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
 		&ibus.Property{
 			Name:      "IBusProperty",
 			Key:       PropKeyMacroTable,
@@ -309,6 +331,8 @@ func GetMacroPropListByConfig(c *config.Config) *ibus.PropList {
 func GetSpellCheckingPropListByConfig(c *config.Config) *ibus.PropList {
 	spellCheckByRules := ibus.PROP_STATE_UNCHECKED
 	spellCheckByDicts := ibus.PROP_STATE_UNCHECKED
+	spellingSuggestion := ibus.PROP_STATE_UNCHECKED
+	englishProtection := ibus.PROP_STATE_UNCHECKED
 
 	// spelling
 	spellingChecked := ibus.PROP_STATE_UNCHECKED
@@ -321,6 +345,12 @@ func GetSpellCheckingPropListByConfig(c *config.Config) *ibus.PropList {
 	if c.IBflags&config.IBspellCheckWithDicts != 0 {
 		spellCheckByDicts = ibus.PROP_STATE_CHECKED
 	}
+	if c.IBflags&config.IBspellingSuggestion != 0 {
+		spellingSuggestion = ibus.PROP_STATE_CHECKED
+	}
+	if c.IBflags&config.IBenglishProtection != 0 {
+		englishProtection = ibus.PROP_STATE_CHECKED
+	}
 	return ibus.NewPropList(
 		&ibus.Property{
 			Name:      "IBusProperty",
@@ -359,6 +389,30 @@ func GetSpellCheckingPropListByConfig(c *config.Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("O")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyEnglishProtection,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Giữ nguyên từ tiếng Anh")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Keep the common English words as typed, e.g. most instead of mót")),
+			Sensitive: true,
+			Visible:   true,
+			State:     englishProtection,
+			Symbol:    dbus.MakeVariant(ibus.NewText("E")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeySpellingSuggestion,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Gợi ý sửa lỗi chính tả")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("List the nearest valid words when a misspelled word is committed (Pre-edit)")),
+			Sensitive: true,
+			Visible:   true,
+			State:     spellingSuggestion,
+			Symbol:    dbus.MakeVariant(ibus.NewText("G")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
 	)
 }
 
@@ -368,6 +422,9 @@ func GetOptionsPropListByConfig(c *config.Config) *ibus.PropList {
 	toneFreeMarkingChecked := ibus.PROP_STATE_UNCHECKED
 	preeditInvisibilityChecked := ibus.PROP_STATE_UNCHECKED
 	x11FakeBackspaceChecked := ibus.PROP_STATE_UNCHECKED
+	wordCompletionChecked := ibus.PROP_STATE_UNCHECKED
+	toneLessTypingChecked := ibus.PROP_STATE_UNCHECKED
+	reconversionChecked := ibus.PROP_STATE_UNCHECKED
 
 	if c.Flags&bamboo.EstdToneStyle != 0 {
 		toneStdChecked = ibus.PROP_STATE_CHECKED
@@ -381,6 +438,15 @@ func GetOptionsPropListByConfig(c *config.Config) *ibus.PropList {
 	if c.IBflags&config.IBpreeditElimination != 0 {
 		x11FakeBackspaceChecked = ibus.PROP_STATE_CHECKED
 	}
+	if c.IBflags&config.IBwordCompletion != 0 {
+		wordCompletionChecked = ibus.PROP_STATE_CHECKED
+	}
+	if c.IBflags&config.IBtoneLessTyping != 0 {
+		toneLessTypingChecked = ibus.PROP_STATE_CHECKED
+	}
+	if c.IBflags&config.IBreconversion != 0 {
+		reconversionChecked = ibus.PROP_STATE_CHECKED
+	}
 
 	return ibus.NewPropList(
 		&ibus.Property{
@@ -419,6 +485,42 @@ func GetOptionsPropListByConfig(c *config.Config) *ibus.PropList {
 			Symbol:    dbus.MakeVariant(ibus.NewText("P")),
 			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
 		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyWordCompletion,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Gợi ý từ")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Word completion (Pre-edit)")),
+			Sensitive: true,
+			Visible:   true,
+			State:     wordCompletionChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("G")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyToneLessTyping,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Gõ không dấu")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Type without diacritics, pick the accented words from the lookup table")),
+			Sensitive: true,
+			Visible:   true,
+			State:     toneLessTypingChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("K")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
+		&ibus.Property{
+			Name:      "IBusProperty",
+			Key:       PropKeyReconversion,
+			Type:      ibus.PROP_TYPE_TOGGLE,
+			Label:     dbus.MakeVariant(ibus.NewText("Sửa lại từ vừa gõ")),
+			Tooltip:   dbus.MakeVariant(ibus.NewText("Re-open the word before the caret on Backspace or a tone key (Pre-edit)")),
+			Sensitive: true,
+			Visible:   true,
+			State:     reconversionChecked,
+			Symbol:    dbus.MakeVariant(ibus.NewText("R")),
+			SubProps:  dbus.MakeVariant(*ibus.NewPropList()),
+		},
 		&ibus.Property{
 			Name:      "IBusProperty",
 			Key:       PropKeyPreeditElimination,
@@ -0,0 +1,239 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BambooEngine/bamboo-core"
+)
+
+const (
+	spellingAlphabet = "abcdefghijklmnopqrstuvwxyz"
+	spellingVowels   = "aeiouy"
+	// an edit of the letters costs more than a tone or a mark, e.g. tuơi is nearer to tươi than to thơi
+	spellingLetterEditCost = 2
+)
+
+// syllableChecker is the Telex engine checking the syllables, it's built once
+// since building an engine parses the input method
+var syllableChecker struct {
+	sync.Mutex
+	preeditor bamboo.IEngine
+}
+
+// the final consonants which only go with the acute and dot tones, e.g. tát, tạt
+var stopFinals = []string{"c", "ch", "p", "t"}
+
+// isValidSyllable checks a Vietnamese syllable against the consonant/vowel
+// rules of bamboo-core and the spelling rules, e.g. tiếng => true, tiéng => false
+func isValidSyllable(word string) bool {
+	word = strings.ToLower(word)
+	if !checkSpellingRules(word, true) {
+		return false
+	}
+	if isValidCVC(word) {
+		return true
+	}
+	// gi takes the i of the rhyme, e.g. giêng is written for gi + iêng
+	var chars = []rune(word)
+	return strings.HasPrefix(word, "gi") && len(chars) > 2 &&
+		bamboo.IsVowel(chars[2]) && isValidCVC("t"+string(chars[1:]))
+}
+
+func isValidCVC(word string) bool {
+	syllableChecker.Lock()
+	defer syllableChecker.Unlock()
+	if syllableChecker.preeditor == nil {
+		syllableChecker.preeditor = bamboo.NewEngine(bamboo.ParseInputMethod(bamboo.GetInputMethodDefinitions(), "Telex"), bamboo.EstdFlags)
+	}
+	syllableChecker.preeditor.Reset()
+	syllableChecker.preeditor.ProcessString(word, bamboo.VietnameseMode)
+	return syllableChecker.preeditor.IsValid(true)
+}
+
+// checkSpellingRules checks the rules bamboo-core leaves out of its consonant/vowel
+// tables: k, gh and ngh go before e, ê, i (k before y too) while c, g and ng go before
+// the other vowels, q goes with u, and the stop finals c, ch, p, t take the acute or
+// the dot tone. An incomplete word may still get its tone, e.g. hoc => học
+func checkSpellingRules(word string, fullComplete bool) bool {
+	// the vowel tables of bamboo-core are lowercase
+	word = strings.ToLower(word)
+	var base = removeDiacritics(word)
+	var first = strings.IndexAny(base, spellingVowels)
+	if first < 0 {
+		return true
+	}
+	var initial, rest = base[:first], base[first:]
+	switch initial {
+	case "k":
+		if !strings.ContainsAny(rest[:1], "eiy") {
+			return false
+		}
+	case "gh", "ngh":
+		if !strings.ContainsAny(rest[:1], "ei") {
+			return false
+		}
+	case "c", "ng":
+		if strings.ContainsAny(rest[:1], "eiy") {
+			return false
+		}
+	case "g":
+		// gi is a consonant of its own, e.g. gì, gia
+		if rest[0] == 'e' {
+			return false
+		}
+	case "q":
+		if rest[0] != 'u' || (fullComplete && len(rest) == 1) {
+			return false
+		}
+	}
+	var final = base[strings.LastIndexAny(base, spellingVowels)+1:]
+	if inStringList(stopFinals, final) {
+		switch getSyllableTone(word) {
+		case bamboo.ToneAcute, bamboo.ToneDot:
+		case bamboo.ToneNone:
+			return !fullComplete
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// getSpellingEdits returns the texts one insertion, deletion, substitution
+// or transposition away from a text written without diacritics
+func getSpellingEdits(text string) []string {
+	var edits []string
+	for i := 0; i <= len(text); i++ {
+		var left, right = text[:i], text[i:]
+		if len(right) > 0 {
+			edits = append(edits, left+right[1:])
+		}
+		if len(right) > 1 {
+			edits = append(edits, left+right[1:2]+right[:1]+right[2:])
+		}
+		for _, c := range spellingAlphabet {
+			if len(right) > 0 && rune(right[0]) != c {
+				edits = append(edits, left+string(c)+right[1:])
+			}
+			edits = append(edits, left+string(c)+right)
+		}
+	}
+	return edits
+}
+
+// getDiacriticDistance counts the tone and the marks which have to be
+// changed to turn word into another, e.g. tuơi => tươi costs 1
+func getDiacriticDistance(word, other string) int {
+	var distance = 0
+	if getSyllableTone(word) != getSyllableTone(other) {
+		distance++
+	}
+	var marks = map[rune]int{}
+	for _, c := range getMarkedChars(word) {
+		marks[c]++
+	}
+	for _, c := range getMarkedChars(other) {
+		marks[c]--
+	}
+	for _, n := range marks {
+		if n < 0 {
+			n = -n
+		}
+		distance += n
+	}
+	return distance
+}
+
+func getSyllableTone(word string) bamboo.Tone {
+	for _, c := range word {
+		if tone := bamboo.FindToneFromChar(c); tone != bamboo.ToneNone {
+			return tone
+		}
+	}
+	return bamboo.ToneNone
+}
+
+// getMarkedChars returns the letters of word carrying a mark (â, ă, ê, ô, ơ, ư, đ)
+func getMarkedChars(word string) []rune {
+	var chars []rune
+	for _, c := range word {
+		var toneless = bamboo.AddToneToChar(c, 0)
+		if bamboo.AddMarkToTonelessChar(toneless, 0) != toneless {
+			chars = append(chars, toneless)
+		}
+	}
+	return chars
+}
+
+// Correct returns at most limit valid syllables of the lexicon nearest to an
+// invalid word, counting the edits of the letters and the diacritics which
+// differ, the letters weighing more, the most frequent words first on a tie.
+func (l *Lexicon) Correct(word string, limit int) []string {
+	word = strings.ToLower(word)
+	var base = removeDiacritics(word)
+	var distances = map[string]int{}
+	var addCandidates = func(key string, cost int) {
+		for _, candidate := range l.toneless[key] {
+			if candidate == word {
+				continue
+			}
+			var distance = cost + getDiacriticDistance(word, candidate)
+			if d, found := distances[candidate]; !found || distance < d {
+				distances[candidate] = distance
+			}
+		}
+	}
+	l.RLock()
+	addCandidates(base, 0)
+	for _, edit := range getSpellingEdits(base) {
+		addCandidates(edit, spellingLetterEditCost)
+	}
+	var words = make([]string, 0, len(distances))
+	for candidate := range distances {
+		words = append(words, candidate)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		var di, dj = distances[words[i]], distances[words[j]]
+		if di != dj {
+			return di < dj
+		}
+		var fi, fj = l.freq[words[i]], l.freq[words[j]]
+		if fi != fj {
+			return fi > fj
+		}
+		return words[i] < words[j]
+	})
+	l.RUnlock()
+
+	var suggestions []string
+	for _, candidate := range words {
+		if len(suggestions) >= limit {
+			break
+		}
+		if isValidSyllable(candidate) {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions
+}
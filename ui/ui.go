@@ -70,8 +70,8 @@ func saveShortcuts(ptr *C.guint32, length int) {
 	config.SaveConfig(cfg, engineName)
 }
 
-func makeSliceFromPtr(ptr *C.guint32, size int) [10]uint32 {
-	var out [10]uint32
+func makeSliceFromPtr(ptr *C.guint32, size int) [16]uint32 {
+	var out [16]uint32
 	slice := (*[1 << 28]C.guint32)(unsafe.Pointer(ptr))[:size:size]
 	for i, elem := range slice[:size] {
 		out[i] = uint32(elem)
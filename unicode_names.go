@@ -0,0 +1,212 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var htmlEntityRegex = regexp.MustCompile(`^&(#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z][A-Za-z0-9]*);$`)
+
+// loadUnicodeNames reads the `code;NAME` lines of a names file
+func loadUnicodeNames(dataFile string) (map[rune]string, error) {
+	f, err := os.Open(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names = map[rune]string{}
+	rd := bufio.NewReader(f)
+	for {
+		line, _, err := rd.ReadLine()
+		if err != nil {
+			break
+		}
+		var s = string(line)
+		if len(s) == 0 || strings.HasPrefix(s, "#") {
+			continue
+		}
+		var list = strings.SplitN(s, ";", 2)
+		if len(list) != 2 {
+			continue
+		}
+		if code, err := strconv.ParseInt(list[0], 16, 32); err == nil {
+			names[rune(code)] = list[1]
+		}
+	}
+	return names, nil
+}
+
+// getUnicodeName returns the name of a character, the names of ideographs and
+// Hangul syllables are not in the names file
+func getUnicodeName(names map[rune]string, c rune) string {
+	if name, found := names[c]; found {
+		return name
+	}
+	switch {
+	case unicode.Is(unicode.Han, c):
+		return fmt.Sprintf("CJK UNIFIED IDEOGRAPH-%04X", c)
+	case unicode.Is(unicode.Tangut, c):
+		return fmt.Sprintf("TANGUT IDEOGRAPH-%04X", c)
+	case c >= 0xAC00 && c <= 0xD7A3:
+		return "HANGUL SYLLABLE"
+	}
+	return ""
+}
+
+// decodeCodePoint returns the text of a code point written in one of the forms:
+// 1ea1 (hexadecimal), #7841 (decimal), &agrave; &#7841; &#x1ea1; (HTML entities)
+func decodeCodePoint(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	if token[0] == '&' {
+		if !strings.HasSuffix(token, ";") {
+			token += ";"
+		}
+		if !htmlEntityRegex.MatchString(token) {
+			return "", false
+		}
+		var text = html.UnescapeString(token)
+		// a partly decoded entity, e.g. &ltx; becomes <x;
+		if text == token || (utf8.RuneCountInString(text) > 1 && strings.HasSuffix(text, ";")) {
+			return "", false
+		}
+		return text, true
+	}
+	var value int64
+	var err error
+	if token[0] == '#' {
+		value, err = strconv.ParseInt(token[1:], 10, 32)
+	} else {
+		value, err = strconv.ParseInt(token, 16, 32)
+	}
+	if err != nil || !utf8.ValidRune(rune(value)) {
+		return "", false
+	}
+	return string(rune(value)), true
+}
+
+// isCodePointKey tells whether a key could be appended to a code point being typed
+func isCodePointKey(token string, key rune) bool {
+	var isHexDigit = (key >= '0' && key <= '9') || (key >= 'a' && key <= 'f') || (key >= 'A' && key <= 'F')
+	switch {
+	case token == "":
+		return isHexDigit || key == '#' || key == '&'
+	case token[0] == '&':
+		return !strings.HasSuffix(token, ";") && (key == '#' || key == ';' || (key < utf8.RuneSelf && unicode.IsLetter(key)) || unicode.IsDigit(key))
+	case token[0] == '#':
+		return key >= '0' && key <= '9'
+	}
+	return isHexDigit
+}
+
+// UnicodeNameIndex finds characters by the words of their names
+type UnicodeNameIndex struct {
+	names map[rune]string
+	trie  *TrieNode
+	chars map[string][]rune
+}
+
+func NewUnicodeNameIndex(names map[rune]string) *UnicodeNameIndex {
+	var idx = &UnicodeNameIndex{
+		names: names,
+		trie:  NewTrie(),
+		chars: map[string][]rune{},
+	}
+	for c, name := range names {
+		for _, word := range splitUnicodeName(name) {
+			if _, found := idx.chars[word]; !found {
+				InsertTrie(idx.trie, word, word)
+			}
+			idx.chars[word] = append(idx.chars[word], c)
+		}
+	}
+	return idx
+}
+
+func splitUnicodeName(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(c rune) bool {
+		return c == ' ' || c == '-'
+	})
+}
+
+// Search returns at most limit characters whose names have a word starting with every word
+// of the query. Names with the fewest words left unmatched come first, then the names having
+// the query words as whole words.
+func (idx *UnicodeNameIndex) Search(query string, limit int) []rune {
+	var queryWords = splitUnicodeName(query)
+	if len(queryWords) == 0 {
+		return nil
+	}
+	var found map[rune]bool
+	for _, queryWord := range queryWords {
+		var matches = map[rune]bool{}
+		for word := range FindPrefix(idx.trie, queryWord) {
+			for _, c := range idx.chars[word] {
+				if found == nil || found[c] {
+					matches[c] = true
+				}
+			}
+		}
+		found = matches
+	}
+	var chars = make([]rune, 0, len(found))
+	var wholeWords = map[rune]int{}
+	var unmatchedWords = map[rune]int{}
+	for c := range found {
+		chars = append(chars, c)
+		for _, word := range splitUnicodeName(idx.names[c]) {
+			var matched = false
+			for _, queryWord := range queryWords {
+				if word == queryWord {
+					wholeWords[c]++
+				}
+				matched = matched || strings.HasPrefix(word, queryWord)
+			}
+			if !matched {
+				unmatchedWords[c]++
+			}
+		}
+	}
+	sort.Slice(chars, func(i, j int) bool {
+		var ci, cj = chars[i], chars[j]
+		if unmatchedWords[ci] != unmatchedWords[cj] {
+			return unmatchedWords[ci] < unmatchedWords[cj]
+		}
+		if wholeWords[ci] != wholeWords[cj] {
+			return wholeWords[ci] > wholeWords[cj]
+		}
+		return ci < cj
+	})
+	if len(chars) > limit {
+		chars = chars[:limit]
+	}
+	return chars
+}
@@ -0,0 +1,51 @@
+/*
+ * Bamboo - A Vietnamese Input method editor
+ * Copyright (C) 2018 Luong Thanh Lam <ltlam93@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"testing"
+)
+
+func TestUnicodeNameSearch(t *testing.T) {
+	var idx = NewUnicodeNameIndex(map[rune]string{
+		0x2192: "RIGHTWARDS ARROW",
+		0x2194: "LEFT RIGHT ARROW",
+		0x2014: "EM DASH",
+		0x2013: "EN DASH",
+		0x00B0: "DEGREE SIGN",
+	})
+	if chars := idx.Search("arrow right", 10); string(chars) != "→↔" {
+		t.Errorf("Searching arrow right, expected →↔, got %s", string(chars))
+	}
+	if chars := idx.Search("em dash", 10); string(chars) != "—" {
+		t.Errorf("Searching em dash, expected —, got %s", string(chars))
+	}
+	if chars := idx.Search("deg", 10); string(chars) != "°" {
+		t.Errorf("Searching deg, expected °, got %s", string(chars))
+	}
+	if chars := idx.Search("star", 10); len(chars) != 0 {
+		t.Errorf("Searching star, expected nothing, got %s", string(chars))
+	}
+}
+
+func TestGetUnicodeName(t *testing.T) {
+	if name := getUnicodeName(nil, '中'); name != "CJK UNIFIED IDEOGRAPH-4E2D" {
+		t.Errorf("Getting the name of 中, got %s", name)
+	}
+}
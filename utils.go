@@ -39,9 +39,13 @@ const (
 	HomePage           = "https://github.com/BambooEngine/ibus-bamboo"
 	CharsetConvertPage = "https://tools.jcisio.com/vietuni/"
 
-	DataDir          = "/usr/share/ibus-bamboo"
-	DictVietnameseCm = "data/vietnamese.cm.dict"
-	DictEmojiOne     = "data/emojione.json"
+	DataDir            = "/usr/share/ibus-bamboo"
+	DictVietnameseCm   = "data/vietnamese.cm.dict"
+	DictVietnameseFreq = "data/vietnamese.freq.txt"
+	DictEnglish        = "data/english.dict"
+	DictEmojiOne       = "data/emojione.json"
+	DictEmojiVi        = "data/emoji.vi.xml"
+	DictUnicodeNames   = "data/unicode.names.txt"
 )
 
 const (
@@ -58,6 +62,9 @@ const (
 	KSViEnSwitch
 	KSEmojiDialog
 	KSHexadecimal
+	KSUnicodeSearch
+	KSSpellingSuggestion
+	KSAddToDictionary
 )
 
 var enabledAuxiliaryTextList = []string{
@@ -100,6 +107,22 @@ func determineMacroCase(str string) uint8 {
 	return VnCaseAllCapital
 }
 
+// removeDiacritics turns a Vietnamese text into its plain ASCII form, e.g. không => khong
+func removeDiacritics(str string) string {
+	var chars = []rune(str)
+	for i, c := range chars {
+		var lower = unicode.ToLower(c)
+		var base = bamboo.AddMarkToTonelessChar(bamboo.AddToneToChar(lower, 0), 0)
+		if base != lower && unicode.IsUpper(c) {
+			base = unicode.ToUpper(base)
+		}
+		if base != lower {
+			chars[i] = base
+		}
+	}
+	return string(chars)
+}
+
 func inKeyList(list []rune, key rune) bool {
 	for _, s := range list {
 		if s == key {
@@ -173,9 +196,20 @@ func sortStrings(list []string) []string {
 	return strList
 }
 
+// loadDictionary merges the word lists of dataFiles, one word per line, and the Hunspell
+// .dic files, whose .aff files are read along with them
 func loadDictionary(dataFiles ...string) (map[string]bool, error) {
 	var data = map[string]bool{}
 	for _, dataFile := range dataFiles {
+		switch filepath.Ext(dataFile) {
+		case ".aff":
+			continue
+		case ".dic":
+			if err := loadHunspellDictionary(dataFile, data); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		f, err := os.Open(dataFile)
 		if err != nil {
 			return nil, err